@@ -3,8 +3,13 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"github.com/hazcod/tail2sen/config"
+	"github.com/hazcod/tail2sen/pkg/deadletter"
+	"github.com/hazcod/tail2sen/pkg/dedup"
+	"github.com/hazcod/tail2sen/pkg/geoip"
 	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/hazcod/tail2sen/pkg/sink"
 	"github.com/hazcod/tail2sen/pkg/tailscale"
 	"github.com/hazcod/tail2sen/pkg/utils"
 	"github.com/sirupsen/logrus"
@@ -17,6 +22,7 @@ func main() {
 	logger.SetLevel(logrus.InfoLevel)
 
 	confFile := flag.String("config", "config.yml", "The YAML configuration file.")
+	flag.Usage = usage
 	flag.Parse()
 
 	conf := config.Config{}
@@ -35,31 +41,156 @@ func main() {
 	}
 	logger.SetLevel(logrusLevel)
 
+	if err := run(ctx, logger, &conf, flag.Arg(0)); err != nil {
+		logger.WithError(err).Fatal("tail2sen failed")
+	}
+}
+
+// run executes a command, or ships the logs when none is given. Errors are returned instead of logged fatally so the
+// sinks are always closed, which flushes what buffered sinks still hold.
+func run(ctx context.Context, logger *logrus.Logger, conf *config.Config, command string) error {
+	var err error
+
+	var geo *geoip.GeoIP
+	if len(conf.GeoIP.Databases) > 0 {
+		geo, err = geoip.New(logger, conf.GeoIP.Databases)
+		if err != nil {
+			return fmt.Errorf("could not load geoip databases: %v", err)
+		}
+
+		defer geo.Close()
+	}
+
+	uploadOptions := msSentinel.UploadOptions{
+		Concurrency:        conf.Microsoft.Upload.Concurrency,
+		RequestsPerSecond:  conf.Microsoft.Upload.RequestsPerSecond,
+		DisableCompression: conf.Microsoft.Upload.DisableCompression,
+		OversizedPolicy:    conf.Microsoft.Upload.OversizedPolicy,
+		MaxAttempts:        conf.DeadLetter.MaxAttempts,
+	}
+
+	if conf.DeadLetter.Path != "" {
+		deadLetterStore, err := deadletter.New(logger, conf.DeadLetter.Path)
+		if err != nil {
+			return fmt.Errorf("could not create dead-letter store: %v", err)
+		}
+
+		uploadOptions.DeadLetter = deadLetterStore
+	}
+
+	var dedupCache *dedup.Cache
+	if conf.Dedup.Path != "" {
+		dedupCache, err = dedup.New(logger, conf.Dedup.Path, conf.Dedup.Window, conf.Dedup.MaxEntries)
+		if err != nil {
+			return fmt.Errorf("could not load dedup cache: %v", err)
+		}
+	}
+
+	// every command manages sentinel resources, otherwise the client is only needed by a sentinel sink
+	var sentinel *msSentinel.Sentinel
+	if command != "" || conf.HasSink(config.SinkSentinel) {
+		sentinel, err = newSentinel(logger, conf, uploadOptions)
+		if err != nil {
+			return fmt.Errorf("could not create MS Sentinel client: %v", err)
+		}
+	}
+
+	switch command {
+	case "":
+	case commandReplayDLQ:
+		replayDeadLetters(ctx, logger, conf, sentinel)
+		return nil
+	case commandProvision:
+		provision(ctx, logger, conf, sentinel)
+		return nil
+	case commandDeployRules:
+		deployRules(ctx, logger, conf, sentinel)
+		return nil
+	case commandDeployContent:
+		deployContent(ctx, logger, conf, sentinel)
+		return nil
+	default:
+		return fmt.Errorf("unknown command '%s'", command)
+	}
+
 	//
 
+	if err := conf.ValidateTailscale(); err != nil {
+		return fmt.Errorf("invalid configuration: %v", err)
+	}
+
 	ts, err := tailscale.New(logger, conf.Tailscale.TailnetName, conf.Tailscale.ClientID, conf.Tailscale.ClientSecret)
 	if err != nil {
-		logger.WithError(err).Fatal("could not create onepassword client")
+		return fmt.Errorf("could not create tailscale client: %v", err)
 	}
 
-	//
-	{
-		sentinel, err := msSentinel.New(logger, msSentinel.Credentials{
-			TenantID:       conf.Microsoft.TenantID,
-			ClientID:       conf.Microsoft.AppID,
-			ClientSecret:   conf.Microsoft.SecretKey,
-			SubscriptionID: conf.Microsoft.SubscriptionID,
-			ResourceGroup:  conf.Microsoft.Audit.ResourceGroup,
-			WorkspaceName:  conf.Microsoft.Audit.WorkspaceName,
-		})
-		if err != nil {
-			logger.WithError(err).Fatal("could not create audit MS Sentinel client")
+	sinks, fanOuts, err := newSinks(ctx, logger, conf, sentinel)
+	defer closeSinks(logger, sinks)
+
+	if err != nil {
+		return err
+	}
+
+	if sentinel != nil && uploadOptions.DeadLetter != nil {
+		if err := sentinel.RetryDeadLetters(ctx, logger, false); err != nil {
+			logger.WithError(err).Warn("could not deliver all dead-letter chunks")
+		}
+	}
+
+	// the legacy collector writes its own _CL table with type suffixed columns, so the DCR table is neither
+	// created nor checked for drift for a stream shipping through it
+	if sentinel != nil && conf.Microsoft.Audit.Collector.WorkspaceID != "" {
+		logger.Info("skipping table update and schema drift check of audit logs shipped through the legacy collector")
+	} else if sentinel != nil {
+		workspace := msSentinel.Workspace{
+			ResourceGroup: conf.Microsoft.Audit.ResourceGroup,
+			Name:          conf.Microsoft.Audit.WorkspaceName,
+		}
+
+		if conf.Microsoft.Audit.UpdateTable {
+			if err := sentinel.CreateTable(ctx, logger, workspace, &msSentinel.AuditSchema, msSentinel.TableOptions{
+				Plan:               conf.Microsoft.Audit.Plan,
+				RetentionDays:      conf.Microsoft.Audit.RetentionDays,
+				TotalRetentionDays: conf.Microsoft.Audit.TotalRetentionDays,
+			}); err != nil {
+				return fmt.Errorf("failed to create MS Sentinel table for audit logs: %v", err)
+			}
 		}
 
+		if err := checkSchemaDrift(ctx, logger, sentinel, workspace, &msSentinel.AuditSchema, conf.Microsoft.Audit.SchemaDrift); err != nil {
+			return err
+		}
+	}
+
+	if sentinel != nil && conf.Microsoft.Network.Collector.WorkspaceID != "" {
+		logger.Info("skipping table update and schema drift check of network logs shipped through the legacy collector")
+	} else if sentinel != nil {
+		workspace := msSentinel.Workspace{
+			ResourceGroup: conf.Microsoft.Network.ResourceGroup,
+			Name:          conf.Microsoft.Network.WorkspaceName,
+		}
+
+		if conf.Microsoft.Network.UpdateTable {
+			if err := sentinel.CreateTable(ctx, logger, workspace, &msSentinel.NetworkSchema, msSentinel.TableOptions{
+				Plan:               conf.Microsoft.Network.Plan,
+				RetentionDays:      conf.Microsoft.Network.RetentionDays,
+				TotalRetentionDays: conf.Microsoft.Network.TotalRetentionDays,
+			}); err != nil {
+				return fmt.Errorf("failed to create MS Sentinel table for network logs: %v", err)
+			}
+		}
+
+		if err := checkSchemaDrift(ctx, logger, sentinel, workspace, &msSentinel.NetworkSchema, conf.Microsoft.Network.SchemaDrift); err != nil {
+			return err
+		}
+	}
+
+	//
+	if fanOut := fanOuts[sink.StreamAudit]; len(fanOut.Targets()) > 0 {
 		logger.Info("fetching tailscale audit logs")
 		auditLogs, err := ts.GetAuditLogs(conf.Tailscale.Lookback)
 		if err != nil {
-			logger.WithError(err).Fatal("failed to fetch audit logs")
+			return fmt.Errorf("failed to fetch audit logs: %v", err)
 		}
 
 		logger.WithField("total", len(auditLogs)).Info("fetched all audit logs")
@@ -68,50 +199,40 @@ func main() {
 
 		convertedLogs, err := utils.ConvertTSAuditToMap(logger, auditLogs)
 		if err != nil {
-			logger.WithError(err).Fatal("could not convert tailscale audit logs")
+			return fmt.Errorf("could not convert tailscale audit logs: %v", err)
 		}
 
-		//
-		if conf.Microsoft.Audit.UpdateTable {
-			if err := sentinel.CreateAuditTable(ctx, logger, "TailscaleAuditLogs_CL", conf.Microsoft.Audit.RetentionDays); err != nil {
-				logger.WithError(err).Fatal("failed to create MS Sentinel table for audit logs")
-			}
+		if err := msSentinel.AuditSchema.Validate(convertedLogs); err != nil {
+			return fmt.Errorf("converted audit logs do not match the table schema: %v", err)
 		}
 
-		if conf.Microsoft.Network.UpdateTable {
-			if err := sentinel.CreateNetworkTable(ctx, logger, "TailscaleNetworkLogs_CL", conf.Microsoft.Network.RetentionDays); err != nil {
-				logger.WithError(err).Fatal("failed to create MS Sentinel table for network logs")
-			}
+		if dedupCache != nil {
+			convertedLogs = dedupCache.Filter(convertedLogs)
 		}
 
 		//
 
-		if err := sentinel.SendLogs(ctx, logger,
-			conf.Microsoft.Audit.DataCollection.Endpoint,
-			conf.Microsoft.Audit.DataCollection.RuleID,
-			conf.Microsoft.Audit.DataCollection.StreamName,
-			convertedLogs); err != nil {
-			logger.WithError(err).Fatal("could not ship audit logs to sentinel")
+		handled, err := fanOut.Write(ctx, sink.StreamAudit, convertedLogs)
+
+		// only remember the logs every required sink handled, the rest is retried on the next run
+		if dedupCache != nil {
+			dedupCache.Mark(sink.Select(convertedLogs, handled))
+
+			if err := dedupCache.Save(); err != nil {
+				logger.WithError(err).Error("could not save dedup cache")
+			}
 		}
-	}
-	//
-	{
-		sentinel, err := msSentinel.New(logger, msSentinel.Credentials{
-			TenantID:       conf.Microsoft.TenantID,
-			ClientID:       conf.Microsoft.AppID,
-			ClientSecret:   conf.Microsoft.SecretKey,
-			SubscriptionID: conf.Microsoft.SubscriptionID,
-			ResourceGroup:  conf.Microsoft.Network.ResourceGroup,
-			WorkspaceName:  conf.Microsoft.Network.WorkspaceName,
-		})
+
 		if err != nil {
-			logger.WithError(err).Fatal("could not create network MS Sentinel client")
+			return fmt.Errorf("could not ship audit logs: %v", err)
 		}
-
+	}
+	//
+	if fanOut := fanOuts[sink.StreamNetwork]; len(fanOut.Targets()) > 0 {
 		logger.Info("fetching tailscale network logs")
 		networkLogs, err := ts.GetNetworkLogs(conf.Tailscale.Lookback)
 		if err != nil {
-			logger.WithError(err).Fatal("failed to fetch network logs")
+			return fmt.Errorf("failed to fetch network logs: %v", err)
 		}
 
 		logger.WithField("total", len(networkLogs)).Info("fetched all network logs")
@@ -120,17 +241,40 @@ func main() {
 
 		convertedLogs, err := utils.ConvertTSNetworkToMap(logger, networkLogs)
 		if err != nil {
-			logger.WithError(err).Fatal("could not convert tailscale network logs")
+			return fmt.Errorf("could not convert tailscale network logs: %v", err)
+		}
+
+		if geo != nil {
+			if err := geo.EnrichNetworkLogs(convertedLogs); err != nil {
+				return fmt.Errorf("could not enrich tailscale network logs: %v", err)
+			}
+		}
+
+		if err := msSentinel.NetworkSchema.Validate(convertedLogs); err != nil {
+			return fmt.Errorf("converted network logs do not match the table schema: %v", err)
+		}
+
+		if dedupCache != nil {
+			convertedLogs = dedupCache.Filter(convertedLogs)
 		}
 
 		//
 
-		if err := sentinel.SendLogs(ctx, logger,
-			conf.Microsoft.Network.DataCollection.Endpoint,
-			conf.Microsoft.Network.DataCollection.RuleID,
-			conf.Microsoft.Network.DataCollection.StreamName,
-			convertedLogs); err != nil {
-			logger.WithError(err).Fatal("could not ship network logs to sentinel")
+		handled, err := fanOut.Write(ctx, sink.StreamNetwork, convertedLogs)
+
+		// only remember the logs every required sink handled, the rest is retried on the next run
+		if dedupCache != nil {
+			dedupCache.Mark(sink.Select(convertedLogs, handled))
+
+			if err := dedupCache.Save(); err != nil {
+				logger.WithError(err).Error("could not save dedup cache")
+			}
+		}
+
+		if err != nil {
+			return fmt.Errorf("could not ship network logs: %v", err)
 		}
 	}
+
+	return nil
 }
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/tail2sen/config"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandDeployContent = "deploy-content"
+)
+
+// deployContent saves the KQL parser functions in the workspace of the table they parse, then deploys the workbook.
+func deployContent(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) {
+	location := conf.Microsoft.Provision.Location
+	if location == "" {
+		logger.Fatal("no provision location configured")
+	}
+
+	functions, err := msSentinel.FunctionTemplates()
+	if err != nil {
+		logger.WithError(err).Fatal("could not load function templates")
+	}
+
+	workspaces := map[string]msSentinel.Workspace{
+		msSentinel.RuleSourceAudit: {
+			ResourceGroup: conf.Microsoft.Audit.ResourceGroup,
+			Name:          conf.Microsoft.Audit.WorkspaceName,
+		},
+		msSentinel.RuleSourceNetwork: {
+			ResourceGroup: conf.Microsoft.Network.ResourceGroup,
+			Name:          conf.Microsoft.Network.WorkspaceName,
+		},
+	}
+
+	params := templateParameters(conf)
+
+	for _, function := range functions {
+		if err := sentinel.DeployFunction(ctx, logger, workspaces[function.Source], function, params); err != nil {
+			logger.WithError(err).WithField("function_name", function.Name).Fatal("could not deploy function")
+		}
+	}
+
+	logger.WithField("total", len(functions)).Info("deployed all functions")
+
+	if err := sentinel.DeployWorkbook(ctx, logger, workspaces, location); err != nil {
+		logger.WithError(err).Fatal("could not deploy workbook")
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+)
+
+// checkSchemaDrift compares the live table with its schema and handles drift according to the configured mode.
+func checkSchemaDrift(ctx context.Context, logger *logrus.Logger, sentinel *msSentinel.Sentinel, workspace msSentinel.Workspace, schema *msSentinel.Schema, mode string) error {
+	if mode == "" {
+		return nil
+	}
+
+	drift, err := sentinel.CheckSchemaDrift(ctx, logger, workspace, schema)
+	if err != nil {
+		if mode == msSentinel.DriftFail {
+			return fmt.Errorf("could not check table schema drift: %v", err)
+		}
+
+		logger.WithError(err).Warn("could not check table schema drift")
+		return nil
+	}
+
+	if !drift.HasDrift() {
+		logger.WithField("table_name", schema.TableName).Debug("table matches its schema")
+		return nil
+	}
+
+	if mode == msSentinel.DriftAdd && len(drift.Missing) > 0 {
+		if err := sentinel.AddMissingColumns(ctx, logger, workspace, drift); err != nil {
+			return fmt.Errorf("could not add missing columns: %v", err)
+		}
+
+		if !drift.HasDrift() {
+			return nil
+		}
+	}
+
+	if mode == msSentinel.DriftFail {
+		return fmt.Errorf("table '%s' drifted from its schema: %s", schema.TableName, drift.String())
+	}
+
+	logger.WithField("table_name", schema.TableName).Warn(drift.String())
+
+	return nil
+}
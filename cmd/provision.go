@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/tail2sen/config"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandProvision = "provision"
+)
+
+// provision creates the data collection endpoint, tables and data collection rules, and prints the resulting config.
+func provision(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) {
+	location := conf.Microsoft.Provision.Location
+	if location == "" {
+		logger.Fatal("no provision location configured")
+	}
+
+	endpoint, err := sentinel.ProvisionEndpoint(ctx, logger,
+		conf.Microsoft.Provision.EndpointGroup, conf.Microsoft.Provision.EndpointName, location)
+	if err != nil {
+		logger.WithError(err).Fatal("could not provision data collection endpoint")
+	}
+
+	outputs := []struct {
+		key          string
+		workspace    msSentinel.Workspace
+		schema       *msSentinel.Schema
+		tableOptions msSentinel.TableOptions
+		ruleName     string
+	}{
+		{
+			key: "audit_output",
+			workspace: msSentinel.Workspace{
+				ResourceGroup: conf.Microsoft.Audit.ResourceGroup,
+				Name:          conf.Microsoft.Audit.WorkspaceName,
+			},
+			schema: &msSentinel.AuditSchema,
+			tableOptions: msSentinel.TableOptions{
+				Plan:               conf.Microsoft.Audit.Plan,
+				RetentionDays:      conf.Microsoft.Audit.RetentionDays,
+				TotalRetentionDays: conf.Microsoft.Audit.TotalRetentionDays,
+			},
+			ruleName: conf.Microsoft.Provision.AuditRuleName,
+		},
+		{
+			key: "network_output",
+			workspace: msSentinel.Workspace{
+				ResourceGroup: conf.Microsoft.Network.ResourceGroup,
+				Name:          conf.Microsoft.Network.WorkspaceName,
+			},
+			schema: &msSentinel.NetworkSchema,
+			tableOptions: msSentinel.TableOptions{
+				Plan:               conf.Microsoft.Network.Plan,
+				RetentionDays:      conf.Microsoft.Network.RetentionDays,
+				TotalRetentionDays: conf.Microsoft.Network.TotalRetentionDays,
+			},
+			ruleName: conf.Microsoft.Provision.NetworkRuleName,
+		},
+	}
+
+	fmt.Println("microsoft:")
+
+	for _, output := range outputs {
+		// the rule can only route into the custom table once it exists
+		if err := sentinel.CreateTable(ctx, logger, output.workspace, output.schema, output.tableOptions); err != nil {
+			logger.WithError(err).WithField("table_name", output.schema.TableName).Fatal("could not provision table")
+		}
+
+		destination, err := sentinel.ProvisionRule(ctx, logger, output.workspace, output.ruleName, location, endpoint, output.schema)
+		if err != nil {
+			logger.WithError(err).WithField("rule_name", output.ruleName).Fatal("could not provision data collection rule")
+		}
+
+		fmt.Printf("  %s:\n", output.key)
+		fmt.Printf("    dcr:\n")
+		fmt.Printf("      endpoint: %q\n", destination.Endpoint)
+		fmt.Printf("      rule_id: %q\n", destination.RuleID)
+		fmt.Printf("      stream_name: %q\n", destination.StreamName)
+	}
+}
@@ -0,0 +1,26 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/tail2sen/config"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandReplayDLQ = "replay-dlq"
+)
+
+// replayDeadLetters pushes every chunk in the dead-letter store, ignoring their backoff,
+// and ships the diverted oversized records to their configured destinations.
+func replayDeadLetters(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) {
+	if err := sentinel.RetryDeadLetters(ctx, logger, true); err != nil {
+		logger.WithError(err).Fatal("could not replay dead-letter chunks")
+	}
+
+	if err := sentinel.ReplayRecords(ctx, logger, sentinelDestinations(conf)); err != nil {
+		logger.WithError(err).Fatal("could not replay dead-letter records")
+	}
+
+	logger.Info("replayed all dead-letter chunks and records")
+}
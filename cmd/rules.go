@@ -0,0 +1,56 @@
+package main
+
+import (
+	"context"
+	"github.com/hazcod/tail2sen/config"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	commandDeployRules = "deploy-rules"
+)
+
+// templateParameters points the bundled content at the configured tables, defaulting to the schema registry.
+func templateParameters(conf *config.Config) msSentinel.TemplateParameters {
+	params := msSentinel.DefaultTemplateParameters()
+
+	if conf.Microsoft.Content.AuditTable != "" {
+		params.AuditTable = conf.Microsoft.Content.AuditTable
+	}
+
+	if conf.Microsoft.Content.NetworkTable != "" {
+		params.NetworkTable = conf.Microsoft.Content.NetworkTable
+	}
+
+	return params
+}
+
+// deployRules creates or updates the bundled analytics rules in the workspace of the table they query.
+func deployRules(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) {
+	rules, err := msSentinel.RuleTemplates()
+	if err != nil {
+		logger.WithError(err).Fatal("could not load analytics rule templates")
+	}
+
+	workspaces := map[string]msSentinel.Workspace{
+		msSentinel.RuleSourceAudit: {
+			ResourceGroup: conf.Microsoft.Audit.ResourceGroup,
+			Name:          conf.Microsoft.Audit.WorkspaceName,
+		},
+		msSentinel.RuleSourceNetwork: {
+			ResourceGroup: conf.Microsoft.Network.ResourceGroup,
+			Name:          conf.Microsoft.Network.WorkspaceName,
+		},
+	}
+
+	params := templateParameters(conf)
+
+	for _, rule := range rules {
+		if err := sentinel.DeployRule(ctx, logger, workspaces[rule.Source], rule, params); err != nil {
+			logger.WithError(err).WithField("rule_name", rule.Name).Fatal("could not deploy analytics rule")
+		}
+	}
+
+	logger.WithField("total", len(rules)).Info("deployed all analytics rules")
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/hazcod/tail2sen/config"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"testing"
+)
+
+func TestTemplateParameters(t *testing.T) {
+	defaults := msSentinel.DefaultTemplateParameters()
+
+	tests := []struct {
+		name         string
+		auditTable   string
+		networkTable string
+		expected     msSentinel.TemplateParameters
+	}{
+		{
+			name:     "defaults",
+			expected: defaults,
+		},
+		{
+			name:       "audit table",
+			auditTable: "Audit_CL",
+			expected:   msSentinel.TemplateParameters{AuditTable: "Audit_CL", NetworkTable: defaults.NetworkTable},
+		},
+		{
+			name:         "both tables",
+			auditTable:   "Audit_CL",
+			networkTable: "Network_CL",
+			expected:     msSentinel.TemplateParameters{AuditTable: "Audit_CL", NetworkTable: "Network_CL"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			conf := &config.Config{}
+			conf.Microsoft.Content.AuditTable = test.auditTable
+			conf.Microsoft.Content.NetworkTable = test.networkTable
+
+			if got := templateParameters(conf); got != test.expected {
+				t.Fatalf("got %+v, expected %+v", got, test.expected)
+			}
+		})
+	}
+}
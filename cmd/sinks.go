@@ -0,0 +1,240 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"github.com/hazcod/tail2sen/config"
+	"github.com/hazcod/tail2sen/pkg/elastic"
+	"github.com/hazcod/tail2sen/pkg/file"
+	"github.com/hazcod/tail2sen/pkg/kafka"
+	"github.com/hazcod/tail2sen/pkg/s3"
+	msSentinel "github.com/hazcod/tail2sen/pkg/sentinel"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/splunk"
+	"github.com/hazcod/tail2sen/pkg/syslog"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// newSentinel creates the MS Sentinel client shared by the commands and the sentinel sink.
+func newSentinel(logger *logrus.Logger, conf *config.Config, uploadOptions msSentinel.UploadOptions) (*msSentinel.Sentinel, error) {
+	return msSentinel.New(logger, msSentinel.Credentials{
+		TenantID:       conf.Microsoft.TenantID,
+		ClientID:       conf.Microsoft.AppID,
+		ClientSecret:   conf.Microsoft.SecretKey,
+		SubscriptionID: conf.Microsoft.SubscriptionID,
+		Cloud:          conf.Microsoft.Cloud,
+
+		Methods:                 conf.Microsoft.Auth.Methods,
+		CertificatePath:         conf.Microsoft.Auth.CertificatePath,
+		CertificatePassword:     conf.Microsoft.Auth.CertificatePassword,
+		ManagedIdentityClientID: conf.Microsoft.Auth.ManagedIdentityClientID,
+		FederatedTokenFile:      conf.Microsoft.Auth.FederatedTokenFile,
+	}, uploadOptions)
+}
+
+func tlsOptions(conf config.TLS) utils.TLSOptions {
+	return utils.TLSOptions{
+		CAFile:             conf.CAFile,
+		CertFile:           conf.CertFile,
+		KeyFile:            conf.KeyFile,
+		InsecureSkipVerify: conf.InsecureSkipVerify,
+	}
+}
+
+// sentinelDestinations returns where the sentinel sink ships every stream to.
+func sentinelDestinations(conf *config.Config) map[sink.Stream]msSentinel.StreamDestination {
+	return map[sink.Stream]msSentinel.StreamDestination{
+		sink.StreamAudit: {
+			Destination: msSentinel.Destination{
+				Endpoint:   conf.Microsoft.Audit.DataCollection.Endpoint,
+				RuleID:     conf.Microsoft.Audit.DataCollection.RuleID,
+				StreamName: conf.Microsoft.Audit.DataCollection.StreamName,
+			},
+			Collector: msSentinel.CollectorDestination{
+				WorkspaceID: conf.Microsoft.Audit.Collector.WorkspaceID,
+				SharedKey:   conf.Microsoft.Audit.Collector.SharedKey,
+				LogType:     conf.Microsoft.Audit.Collector.LogType,
+			},
+		},
+		sink.StreamNetwork: {
+			Destination: msSentinel.Destination{
+				Endpoint:   conf.Microsoft.Network.DataCollection.Endpoint,
+				RuleID:     conf.Microsoft.Network.DataCollection.RuleID,
+				StreamName: conf.Microsoft.Network.DataCollection.StreamName,
+			},
+			Collector: msSentinel.CollectorDestination{
+				WorkspaceID: conf.Microsoft.Network.Collector.WorkspaceID,
+				SharedKey:   conf.Microsoft.Network.Collector.SharedKey,
+				LogType:     conf.Microsoft.Network.Collector.LogType,
+			},
+		},
+	}
+}
+
+// newSink creates a single configured sink.
+func newSink(logger *logrus.Logger, conf *config.Config, sinkConf config.Sink, sentinel *msSentinel.Sentinel) (sink.Sink, error) {
+	switch sinkConf.Type {
+	case config.SinkSentinel:
+		// only the streams of the sink are written, and checked by its health check
+		destinations := make(map[sink.Stream]msSentinel.StreamDestination, len(sinkConf.Streams))
+		for _, stream := range sinkConf.Streams {
+			destinations[sink.Stream(stream)] = sentinelDestinations(conf)[sink.Stream(stream)]
+		}
+
+		return msSentinel.NewSink(logger, sinkConf.Name, sentinel, destinations), nil
+
+	case config.SinkSplunk:
+		return splunk.New(logger, sinkConf.Name, splunk.Options{
+			URL:                sinkConf.Splunk.URL,
+			Token:              sinkConf.Splunk.Token,
+			TLS:                tlsOptions(sinkConf.Splunk.TLS),
+			DisableCompression: sinkConf.Splunk.DisableCompression,
+			MaxBatchBytes:      sinkConf.Splunk.MaxBatchBytes,
+			Ack:                sinkConf.Splunk.Ack,
+			AckTimeout:         sinkConf.Splunk.AckTimeout,
+			Channel:            sinkConf.Splunk.Channel,
+			Streams: map[sink.Stream]splunk.StreamOptions{
+				sink.StreamAudit:   splunk.StreamOptions(sinkConf.Splunk.Audit),
+				sink.StreamNetwork: splunk.StreamOptions(sinkConf.Splunk.Network),
+			},
+		})
+
+	case config.SinkElastic:
+		return elastic.New(logger, sinkConf.Name, elastic.Options{
+			URL:              sinkConf.Elastic.URL,
+			Username:         sinkConf.Elastic.Username,
+			Password:         sinkConf.Elastic.Password,
+			APIKey:           sinkConf.Elastic.APIKey,
+			TLS:              tlsOptions(sinkConf.Elastic.TLS),
+			MaxBatchBytes:    sinkConf.Elastic.MaxBatchBytes,
+			InstallTemplates: sinkConf.Elastic.InstallTemplates,
+			Streams: map[sink.Stream]elastic.StreamOptions{
+				sink.StreamAudit:   elastic.StreamOptions(sinkConf.Elastic.Audit),
+				sink.StreamNetwork: elastic.StreamOptions(sinkConf.Elastic.Network),
+			},
+		})
+
+	case config.SinkFile:
+		fileMode, dirMode, err := sinkConf.File.Modes()
+		if err != nil {
+			return nil, err
+		}
+
+		return file.New(logger, sinkConf.Name, file.Options{
+			Dir:            sinkConf.File.Path,
+			MaxBytes:       sinkConf.File.MaxBytes,
+			RotateInterval: sinkConf.File.RotateInterval,
+			Compress:       sinkConf.File.Compress,
+			MaxFiles:       sinkConf.File.MaxFiles,
+			FileMode:       fileMode,
+			DirMode:        dirMode,
+			Streams: map[sink.Stream]file.StreamOptions{
+				sink.StreamAudit:   file.StreamOptions(sinkConf.File.Audit),
+				sink.StreamNetwork: file.StreamOptions(sinkConf.File.Network),
+			},
+		})
+
+	case config.SinkSyslog:
+		return syslog.New(logger, sinkConf.Name, syslog.Options{
+			Network:        sinkConf.Syslog.Network,
+			Address:        sinkConf.Syslog.Address,
+			TLS:            tlsOptions(sinkConf.Syslog.TLS),
+			Format:         sinkConf.Syslog.Format,
+			Framing:        sinkConf.Syslog.Framing,
+			Facility:       sinkConf.Syslog.Facility,
+			Hostname:       sinkConf.Syslog.Hostname,
+			AppName:        sinkConf.Syslog.AppName,
+			WriteTimeout:   sinkConf.Syslog.WriteTimeout,
+			MaxMessageSize: sinkConf.Syslog.MaxMessageSize,
+		})
+
+	case config.SinkS3:
+		return s3.New(logger, sinkConf.Name, s3.Options{
+			Endpoint:        sinkConf.S3.Endpoint,
+			Region:          sinkConf.S3.Region,
+			Bucket:          sinkConf.S3.Bucket,
+			PathStyle:       sinkConf.S3.PathStyle,
+			AccessKeyID:     sinkConf.S3.AccessKeyID,
+			SecretAccessKey: sinkConf.S3.SecretAccessKey,
+			SessionToken:    sinkConf.S3.SessionToken,
+			TLS:             tlsOptions(sinkConf.S3.TLS),
+			Prefix:          sinkConf.S3.Prefix,
+			Tailnet:         conf.Tailscale.TailnetName,
+			MaxObjectBytes:  sinkConf.S3.MaxObjectBytes,
+		})
+
+	case config.SinkKafka:
+		return kafka.New(logger, sinkConf.Name, kafka.Options{
+			Brokers:            sinkConf.Kafka.Brokers,
+			ClientID:           sinkConf.Kafka.ClientID,
+			UseTLS:             sinkConf.Kafka.UseTLS,
+			TLS:                tlsOptions(sinkConf.Kafka.TLS),
+			Mechanism:          sinkConf.Kafka.SASL.Mechanism,
+			Username:           sinkConf.Kafka.SASL.Username,
+			Password:           sinkConf.Kafka.SASL.Password,
+			Compression:        sinkConf.Kafka.Compression,
+			BatchMaxBytes:      sinkConf.Kafka.BatchMaxBytes,
+			Linger:             sinkConf.Kafka.Linger,
+			DisableIdempotence: sinkConf.Kafka.DisableIdempotence,
+			Streams: map[sink.Stream]kafka.StreamOptions{
+				sink.StreamAudit:   kafka.StreamOptions(sinkConf.Kafka.Audit),
+				sink.StreamNetwork: kafka.StreamOptions(sinkConf.Kafka.Network),
+			},
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown sink type '%s'", sinkConf.Type)
+	}
+}
+
+// newSinks creates every configured sink and the fan-out of each stream.
+// Required sinks that are unhealthy abort the run, optional ones are left out.
+// The sinks created so far are returned with an error too, so they can be closed.
+func newSinks(ctx context.Context, logger *logrus.Logger, conf *config.Config, sentinel *msSentinel.Sentinel) ([]sink.Sink, map[sink.Stream]*sink.FanOut, error) {
+	var sinks []sink.Sink
+	targets := make(map[sink.Stream][]sink.Target)
+
+	for _, sinkConf := range conf.Sinks {
+		sinkLogger := logger.WithField("sink", sinkConf.Name)
+
+		s, err := newSink(logger, conf, sinkConf, sentinel)
+		if err != nil {
+			return sinks, nil, fmt.Errorf("could not create sink '%s': %v", sinkConf.Name, err)
+		}
+
+		sinks = append(sinks, s)
+
+		if err := s.Health(ctx); err != nil {
+			if !sinkConf.Optional {
+				return sinks, nil, fmt.Errorf("sink '%s' is unhealthy: %v", sinkConf.Name, err)
+			}
+
+			sinkLogger.WithError(err).Warn("skipping unhealthy optional sink")
+			continue
+		}
+
+		for _, stream := range sinkConf.Streams {
+			targets[sink.Stream(stream)] = append(targets[sink.Stream(stream)], sink.Target{
+				Sink:     s,
+				Optional: sinkConf.Optional,
+			})
+		}
+	}
+
+	fanOuts := map[sink.Stream]*sink.FanOut{
+		sink.StreamAudit:   sink.NewFanOut(logger, targets[sink.StreamAudit]...),
+		sink.StreamNetwork: sink.NewFanOut(logger, targets[sink.StreamNetwork]...),
+	}
+
+	return sinks, fanOuts, nil
+}
+
+// closeSinks closes every sink, errors are only logged since all data was flushed already.
+func closeSinks(logger *logrus.Logger, sinks []sink.Sink) {
+	for _, s := range sinks {
+		if err := s.Close(); err != nil {
+			logger.WithError(err).WithField("sink", s.Name()).Warn("could not close sink")
+		}
+	}
+}
@@ -0,0 +1,22 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+)
+
+func usage() {
+	out := flag.CommandLine.Output()
+
+	fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+	fmt.Fprintf(out, "Without a command, tailscale logs are fetched and shipped to MS Sentinel.\n\n")
+	fmt.Fprintf(out, "Commands:\n")
+	fmt.Fprintf(out, "  %-15s push every dead-letter chunk, ignoring backoff, and replay diverted records\n", commandReplayDLQ)
+	fmt.Fprintf(out, "  %-15s create the data collection endpoint, rules and tables, then print their config\n", commandProvision)
+	fmt.Fprintf(out, "  %-15s create or update the bundled Sentinel analytics rules\n", commandDeployRules)
+	fmt.Fprintf(out, "  %-15s create or update the KQL parser functions and the Tailscale workbook\n\n", commandDeployContent)
+	fmt.Fprintf(out, "Flags:\n")
+
+	flag.PrintDefaults()
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	validator "github.com/asaskevich/govalidator"
+	"github.com/hazcod/tail2sen/pkg/sentinel"
 	"github.com/kelseyhightower/envconfig"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
@@ -14,6 +15,20 @@ import (
 const (
 	defaultLogLevel = "DEBUG"
 	defaultLookback = "1.2h"
+
+	defaultDedupWindow     = "24h"
+	defaultDedupMaxEntries = 250000
+
+	defaultOversizedPolicy = "truncate"
+
+	defaultAuthMethod = "secret"
+
+	defaultAuditLogType   = "TailscaleAuditLogs"
+	defaultNetworkLogType = "TailscaleNetworkLogs"
+
+	defaultProvisionEndpointName    = "tail2sen-dce"
+	defaultProvisionAuditRuleName   = "tail2sen-audit-dcr"
+	defaultProvisionNetworkRuleName = "tail2sen-network-dcr"
 )
 
 type Config struct {
@@ -28,11 +43,59 @@ type Config struct {
 		Lookback     time.Duration `yaml:"lookback" env:"TS_LOOKBACK"`
 	} `yaml:"tailscale"`
 
+	GeoIP struct {
+		Databases []string `yaml:"databases" env:"GEOIP_DATABASES"`
+	} `yaml:"geoip"`
+
+	Dedup struct {
+		Path       string        `yaml:"path" env:"DEDUP_PATH"`
+		Window     time.Duration `yaml:"window" env:"DEDUP_WINDOW"`
+		MaxEntries int           `yaml:"max_entries" env:"DEDUP_MAX_ENTRIES"`
+	} `yaml:"dedup"`
+
+	// Sinks defaults to a single sentinel sink for all streams.
+	Sinks []Sink `yaml:"sinks"`
+
+	DeadLetter struct {
+		Path        string `yaml:"path" env:"DEAD_LETTER_PATH"`
+		MaxAttempts int    `yaml:"max_attempts" env:"DEAD_LETTER_MAX_ATTEMPTS"`
+	} `yaml:"dead_letter"`
+
 	Microsoft struct {
 		AppID          string `yaml:"app_id" env:"MS_APP_ID" valid:"minstringlength(3)"`
 		SecretKey      string `yaml:"secret_key" env:"MS_SECRET_KEY" valid:"minstringlength(3)"`
 		TenantID       string `yaml:"tenant_id" env:"MS_TENANT_ID" valid:"minstringlength(3)"`
 		SubscriptionID string `yaml:"subscription_id" env:"MS_SUB_ID" valid:"minstringlength(3)"`
+		Cloud          string `yaml:"cloud" env:"MS_CLOUD" valid:"in(public|government|china)"`
+
+		Auth struct {
+			Methods                 []string `yaml:"methods" env:"MS_AUTH_METHODS"`
+			CertificatePath         string   `yaml:"certificate_path" env:"MS_AUTH_CERT_PATH"`
+			CertificatePassword     string   `yaml:"certificate_password" env:"MS_AUTH_CERT_PASSWORD"`
+			ManagedIdentityClientID string   `yaml:"managed_identity_client_id" env:"MS_AUTH_MI_CLIENT_ID"`
+			FederatedTokenFile      string   `yaml:"federated_token_file" env:"MS_AUTH_TOKEN_FILE"`
+		} `yaml:"auth"`
+
+		Upload struct {
+			Concurrency        int     `yaml:"concurrency" env:"MS_UPLOAD_CONCURRENCY"`
+			RequestsPerSecond  float64 `yaml:"requests_per_second" env:"MS_UPLOAD_RPS"`
+			DisableCompression bool    `yaml:"disable_compression" env:"MS_UPLOAD_DISABLE_COMPRESSION"`
+			OversizedPolicy    string  `yaml:"oversized_policy" env:"MS_UPLOAD_OVERSIZED_POLICY" valid:"in(truncate|split|deadletter)"`
+		} `yaml:"upload"`
+
+		Provision struct {
+			Location        string `yaml:"location" env:"MS_PROVISION_LOCATION"`
+			EndpointName    string `yaml:"endpoint_name" env:"MS_PROVISION_DCE_NAME"`
+			EndpointGroup   string `yaml:"endpoint_resource_group" env:"MS_PROVISION_DCE_RG"`
+			AuditRuleName   string `yaml:"audit_rule_name" env:"MS_PROVISION_AD_DCR_NAME"`
+			NetworkRuleName string `yaml:"network_rule_name" env:"MS_PROVISION_NW_DCR_NAME"`
+		} `yaml:"provision"`
+
+		// Content overrides the tables queried by the bundled rules, functions and workbook.
+		Content struct {
+			AuditTable   string `yaml:"audit_table" env:"MS_CONTENT_AD_TABLE" valid:"matches(^[A-Za-z0-9_]+$)"`
+			NetworkTable string `yaml:"network_table" env:"MS_CONTENT_NW_TABLE" valid:"matches(^[A-Za-z0-9_]+$)"`
+		} `yaml:"content"`
 
 		Audit struct {
 			DataCollection struct {
@@ -41,11 +104,21 @@ type Config struct {
 				StreamName string `yaml:"stream_name" env:"MS_AD_DCR_STREAM" valid:"minstringlength(3)"`
 			} `yaml:"dcr"`
 
+			// Collector ships through the legacy HTTP Data Collector API instead of the data collection rule.
+			Collector struct {
+				WorkspaceID string `yaml:"workspace_id" env:"MS_AD_COLLECTOR_WS_ID"`
+				SharedKey   string `yaml:"shared_key" env:"MS_AD_COLLECTOR_KEY"`
+				LogType     string `yaml:"log_type" env:"MS_AD_COLLECTOR_LOG_TYPE"`
+			} `yaml:"collector"`
+
 			ResourceGroup string `yaml:"resource_group" env:"MS_AD_RSG_ID" valid:"minstringlength(3)"`
 			WorkspaceName string `yaml:"workspace_name" env:"MS_AD_WS_NAME" valid:"minstringlength(3)"`
 
-			RetentionDays uint32 `yaml:"retention_days" env:"MS_AD_RETENTION_DAYS"`
-			UpdateTable   bool   `yaml:"update_table" env:"MS_AD_UPDATE_TABLE"`
+			RetentionDays      uint32 `yaml:"retention_days" env:"MS_AD_RETENTION_DAYS"`
+			TotalRetentionDays uint32 `yaml:"total_retention_days" env:"MS_AD_TOTAL_RETENTION_DAYS"`
+			Plan               string `yaml:"plan" env:"MS_AD_TABLE_PLAN" valid:"in(Analytics|Basic|Auxiliary)"`
+			UpdateTable        bool   `yaml:"update_table" env:"MS_AD_UPDATE_TABLE"`
+			SchemaDrift        string `yaml:"schema_drift" env:"MS_AD_SCHEMA_DRIFT" valid:"in(warn|fail|add)"`
 		} `yaml:"audit_output"`
 
 		Network struct {
@@ -55,11 +128,21 @@ type Config struct {
 				StreamName string `yaml:"stream_name" env:"MS_NW_DCR_STREAM" valid:"minstringlength(3)"`
 			} `yaml:"dcr"`
 
+			// Collector ships through the legacy HTTP Data Collector API instead of the data collection rule.
+			Collector struct {
+				WorkspaceID string `yaml:"workspace_id" env:"MS_NW_COLLECTOR_WS_ID"`
+				SharedKey   string `yaml:"shared_key" env:"MS_NW_COLLECTOR_KEY"`
+				LogType     string `yaml:"log_type" env:"MS_NW_COLLECTOR_LOG_TYPE"`
+			} `yaml:"collector"`
+
 			ResourceGroup string `yaml:"resource_group" env:"MS_NW_RSG_ID" valid:"minstringlength(3)"`
 			WorkspaceName string `yaml:"workspace_name" env:"MS_NW_WS_NAME" valid:"minstringlength(3)"`
 
-			RetentionDays uint32 `yaml:"retention_days" env:"MS_NW_RETENTION_DAYS"`
-			UpdateTable   bool   `yaml:"update_table" env:"MS_NW_UPDATE_TABLE"`
+			RetentionDays      uint32 `yaml:"retention_days" env:"MS_NW_RETENTION_DAYS"`
+			TotalRetentionDays uint32 `yaml:"total_retention_days" env:"MS_NW_TOTAL_RETENTION_DAYS"`
+			Plan               string `yaml:"plan" env:"MS_NW_TABLE_PLAN" valid:"in(Analytics|Basic|Auxiliary)"`
+			UpdateTable        bool   `yaml:"update_table" env:"MS_NW_UPDATE_TABLE"`
+			SchemaDrift        string `yaml:"schema_drift" env:"MS_NW_SCHEMA_DRIFT" valid:"in(warn|fail|add)"`
 		} `yaml:"network_output"`
 	} `yaml:"microsoft"`
 }
@@ -77,8 +160,106 @@ func (c *Config) Validate() error {
 		}
 	}
 
-	if c.Tailscale.ClientID == "" {
-		return errors.New("no clientid provided")
+	if c.Dedup.Window.Seconds() == 0 {
+		var err error
+		c.Dedup.Window, err = time.ParseDuration(defaultDedupWindow)
+		if err != nil {
+			logrus.WithError(err).WithField("defaultDedupWindow", defaultDedupWindow).Fatal("could not parse dedup window")
+		}
+	}
+
+	if c.Dedup.MaxEntries == 0 {
+		c.Dedup.MaxEntries = defaultDedupMaxEntries
+	}
+
+	if c.Dedup.Path != "" && c.Dedup.Window < c.Tailscale.Lookback {
+		return fmt.Errorf("dedup window %s is shorter than the lookback %s", c.Dedup.Window, c.Tailscale.Lookback)
+	}
+
+	if c.Microsoft.Upload.OversizedPolicy == "" {
+		c.Microsoft.Upload.OversizedPolicy = defaultOversizedPolicy
+	}
+
+	if c.Microsoft.Upload.OversizedPolicy == "deadletter" && c.DeadLetter.Path == "" {
+		return errors.New("oversized policy deadletter requires a dead_letter path")
+	}
+
+	if err := c.validateSinks(); err != nil {
+		return err
+	}
+
+	if len(c.Microsoft.Auth.Methods) == 0 {
+		c.Microsoft.Auth.Methods = []string{defaultAuthMethod}
+	}
+
+	// azure credentials are only needed when shipping to sentinel
+	if c.HasSink(SinkSentinel) {
+		for _, method := range c.Microsoft.Auth.Methods {
+			switch method {
+			case "secret":
+				if c.Microsoft.AppID == "" || c.Microsoft.SecretKey == "" {
+					return errors.New("auth method secret requires an app_id and secret_key")
+				}
+			case "certificate":
+				if c.Microsoft.AppID == "" || c.Microsoft.Auth.CertificatePath == "" {
+					return errors.New("auth method certificate requires an app_id and certificate_path")
+				}
+			case "managed_identity", "workload_identity", "cli", "default":
+			default:
+				return fmt.Errorf("unknown auth method '%s'", method)
+			}
+		}
+	}
+
+	if c.Microsoft.Audit.Collector.LogType == "" {
+		c.Microsoft.Audit.Collector.LogType = defaultAuditLogType
+	}
+
+	if c.Microsoft.Network.Collector.LogType == "" {
+		c.Microsoft.Network.Collector.LogType = defaultNetworkLogType
+	}
+
+	if c.Microsoft.Audit.Collector.WorkspaceID != "" && c.Microsoft.Audit.Collector.SharedKey == "" {
+		return errors.New("audit collector requires a shared_key")
+	}
+
+	if c.Microsoft.Network.Collector.WorkspaceID != "" && c.Microsoft.Network.Collector.SharedKey == "" {
+		return errors.New("network collector requires a shared_key")
+	}
+
+	// bad retention settings would otherwise only fail once the table is updated
+	auditTable := sentinel.TableOptions{
+		Plan:               c.Microsoft.Audit.Plan,
+		RetentionDays:      c.Microsoft.Audit.RetentionDays,
+		TotalRetentionDays: c.Microsoft.Audit.TotalRetentionDays,
+	}
+	if err := auditTable.Validate(); err != nil {
+		return fmt.Errorf("invalid audit table options: %v", err)
+	}
+
+	networkTable := sentinel.TableOptions{
+		Plan:               c.Microsoft.Network.Plan,
+		RetentionDays:      c.Microsoft.Network.RetentionDays,
+		TotalRetentionDays: c.Microsoft.Network.TotalRetentionDays,
+	}
+	if err := networkTable.Validate(); err != nil {
+		return fmt.Errorf("invalid network table options: %v", err)
+	}
+
+	if c.Microsoft.Provision.EndpointName == "" {
+		c.Microsoft.Provision.EndpointName = defaultProvisionEndpointName
+	}
+
+	if c.Microsoft.Provision.EndpointGroup == "" {
+		c.Microsoft.Provision.EndpointGroup = c.Microsoft.Audit.ResourceGroup
+	}
+
+	if c.Microsoft.Provision.AuditRuleName == "" {
+		c.Microsoft.Provision.AuditRuleName = defaultProvisionAuditRuleName
+	}
+
+	if c.Microsoft.Provision.NetworkRuleName == "" {
+		c.Microsoft.Provision.NetworkRuleName = defaultProvisionNetworkRuleName
 	}
 
 	if valid, err := validator.ValidateStruct(c); !valid || err != nil {
@@ -88,6 +269,23 @@ func (c *Config) Validate() error {
 	return nil
 }
 
+// ValidateTailscale checks the tailscale credentials, they are only needed when shipping logs and not by the commands.
+func (c *Config) ValidateTailscale() error {
+	if c.Tailscale.ClientID == "" {
+		return errors.New("no clientid provided")
+	}
+
+	if c.Tailscale.ClientSecret == "" {
+		return errors.New("no client secret provided")
+	}
+
+	if c.Tailscale.TailnetName == "" {
+		return errors.New("no tailnet provided")
+	}
+
+	return nil
+}
+
 func (c *Config) Load(path string) error {
 	if path != "" {
 		configBytes, err := os.ReadFile(path)
@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SinkSentinel = "sentinel"
+	SinkSplunk   = "splunk"
+	SinkElastic  = "elastic"
+	SinkFile     = "file"
+	SinkSyslog   = "syslog"
+	SinkS3       = "s3"
+	SinkKafka    = "kafka"
+
+	StreamAudit   = "audit"
+	StreamNetwork = "network"
+
+	// secretEnvPrefix and secretFilePrefix let sink secrets be read from the environment or a file
+	secretEnvPrefix  = "env:"
+	secretFilePrefix = "file:"
+)
+
+// TLS configures the connection of a sink to its destination.
+type TLS struct {
+	CAFile             string `yaml:"ca_file"`
+	CertFile           string `yaml:"cert_file"`
+	KeyFile            string `yaml:"key_file"`
+	InsecureSkipVerify bool   `yaml:"insecure_skip_verify"`
+}
+
+// Sink is a destination the streams are fanned out to.
+type Sink struct {
+	// Name identifies the sink, defaults to its type.
+	Name string `yaml:"name"`
+	Type string `yaml:"type" valid:"in(sentinel|splunk|elastic|file|syslog|s3|kafka)"`
+	// Streams written to the sink, defaults to all streams.
+	Streams []string `yaml:"streams"`
+	// Optional sinks may fail without failing the run.
+	Optional bool `yaml:"optional"`
+
+	Splunk  SplunkSink  `yaml:"splunk"`
+	Elastic ElasticSink `yaml:"elastic"`
+	File    FileSink    `yaml:"file"`
+	Syslog  SyslogSink  `yaml:"syslog"`
+	S3      S3Sink      `yaml:"s3"`
+	Kafka   KafkaSink   `yaml:"kafka"`
+}
+
+type SplunkStream struct {
+	Index      string `yaml:"index"`
+	SourceType string `yaml:"sourcetype"`
+	Source     string `yaml:"source"`
+}
+
+type SplunkSink struct {
+	URL                string        `yaml:"url"`
+	Token              string        `yaml:"token"`
+	TLS                TLS           `yaml:"tls"`
+	DisableCompression bool          `yaml:"disable_compression"`
+	MaxBatchBytes      int           `yaml:"max_batch_bytes"`
+	Ack                bool          `yaml:"ack"`
+	AckTimeout         time.Duration `yaml:"ack_timeout"`
+	Channel            string        `yaml:"channel"`
+	Audit              SplunkStream  `yaml:"audit"`
+	Network            SplunkStream  `yaml:"network"`
+}
+
+type ElasticStream struct {
+	Index      string `yaml:"index"`
+	DataStream bool   `yaml:"data_stream"`
+}
+
+type ElasticSink struct {
+	URL              string        `yaml:"url"`
+	Username         string        `yaml:"username"`
+	Password         string        `yaml:"password"`
+	APIKey           string        `yaml:"api_key"`
+	TLS              TLS           `yaml:"tls"`
+	MaxBatchBytes    int           `yaml:"max_batch_bytes"`
+	InstallTemplates bool          `yaml:"install_templates"`
+	Audit            ElasticStream `yaml:"audit"`
+	Network          ElasticStream `yaml:"network"`
+}
+
+type FileStream struct {
+	Name string `yaml:"name"`
+}
+
+type FileSink struct {
+	Path           string        `yaml:"path"`
+	MaxBytes       int64         `yaml:"max_bytes"`
+	RotateInterval time.Duration `yaml:"rotate_interval"`
+	Compress       bool          `yaml:"compress"`
+	MaxFiles       int           `yaml:"max_files"`
+	FileMode       string        `yaml:"file_mode"`
+	DirMode        string        `yaml:"dir_mode"`
+	Audit          FileStream    `yaml:"audit"`
+	Network        FileStream    `yaml:"network"`
+}
+
+// Modes returns the octal file and directory modes, zero when not configured.
+func (f FileSink) Modes() (os.FileMode, os.FileMode, error) {
+	fileMode, err := parseMode(f.FileMode)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid file_mode: %v", err)
+	}
+
+	dirMode, err := parseMode(f.DirMode)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid dir_mode: %v", err)
+	}
+
+	return fileMode, dirMode, nil
+}
+
+// parseMode parses an octal permission mode such as 0640.
+func parseMode(mode string) (os.FileMode, error) {
+	if mode == "" {
+		return 0, nil
+	}
+
+	parsed, err := strconv.ParseUint(strings.TrimPrefix(mode, "0o"), 8, 32)
+	if err != nil || parsed > 0o777 {
+		return 0, fmt.Errorf("'%s' is not an octal permission mode", mode)
+	}
+
+	return os.FileMode(parsed), nil
+}
+
+type SyslogSink struct {
+	Network        string        `yaml:"network" valid:"in(udp|tcp|tls)"`
+	Address        string        `yaml:"address"`
+	TLS            TLS           `yaml:"tls"`
+	Format         string        `yaml:"format" valid:"in(structured|cef)"`
+	Framing        string        `yaml:"framing" valid:"in(octet-counting|non-transparent)"`
+	Facility       int           `yaml:"facility"`
+	Hostname       string        `yaml:"hostname"`
+	AppName        string        `yaml:"app_name"`
+	WriteTimeout   time.Duration `yaml:"write_timeout"`
+	MaxMessageSize int           `yaml:"max_message_size"`
+}
+
+type S3Sink struct {
+	Endpoint        string `yaml:"endpoint"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	PathStyle       bool   `yaml:"path_style"`
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	SessionToken    string `yaml:"session_token"`
+	TLS             TLS    `yaml:"tls"`
+	Prefix          string `yaml:"prefix"`
+	MaxObjectBytes  int    `yaml:"max_object_bytes"`
+}
+
+type KafkaStream struct {
+	Topic string `yaml:"topic"`
+	Key   string `yaml:"key"`
+}
+
+type KafkaSink struct {
+	Brokers            []string      `yaml:"brokers"`
+	ClientID           string        `yaml:"client_id"`
+	UseTLS             bool          `yaml:"use_tls"`
+	TLS                TLS           `yaml:"tls"`
+	SASL               KafkaSASL     `yaml:"sasl"`
+	Compression        string        `yaml:"compression" valid:"in(none|gzip|snappy|lz4|zstd)"`
+	BatchMaxBytes      int32         `yaml:"batch_max_bytes"`
+	Linger             time.Duration `yaml:"linger"`
+	DisableIdempotence bool          `yaml:"disable_idempotence"`
+	Audit              KafkaStream   `yaml:"audit"`
+	Network            KafkaStream   `yaml:"network"`
+}
+
+type KafkaSASL struct {
+	Mechanism string `yaml:"mechanism" valid:"in(plain|scram-sha-256|scram-sha-512)"`
+	Username  string `yaml:"username"`
+	Password  string `yaml:"password"`
+}
+
+// resolveSecret replaces a secret in the env:NAME or file:/path syntax with the value of the variable or the file,
+// so secrets do not have to be stored in the configuration file. Other values are kept as they are.
+func resolveSecret(secret *string) error {
+	switch {
+	case strings.HasPrefix(*secret, secretEnvPrefix):
+		name := strings.TrimPrefix(*secret, secretEnvPrefix)
+
+		value, ok := os.LookupEnv(name)
+		if !ok {
+			return fmt.Errorf("environment variable '%s' is not set", name)
+		}
+
+		*secret = value
+
+	case strings.HasPrefix(*secret, secretFilePrefix):
+		path := strings.TrimPrefix(*secret, secretFilePrefix)
+
+		value, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("could not read secret file: %v", err)
+		}
+
+		*secret = strings.TrimRight(string(value), "\r\n")
+	}
+
+	return nil
+}
+
+// secrets returns the credentials of a sink that are resolved with resolveSecret.
+func (s *Sink) secrets() []*string {
+	return []*string{
+		&s.Splunk.Token,
+		&s.Elastic.Password,
+		&s.Elastic.APIKey,
+		&s.S3.AccessKeyID,
+		&s.S3.SecretAccessKey,
+		&s.S3.SessionToken,
+		&s.Kafka.SASL.Password,
+	}
+}
+
+// validateSinks fills in the defaults of the sinks and checks each has the settings of its type.
+func (c *Config) validateSinks() error {
+	if len(c.Sinks) == 0 {
+		c.Sinks = []Sink{{Type: SinkSentinel}}
+	}
+
+	sinkNames := make(map[string]struct{}, len(c.Sinks))
+
+	for i := range c.Sinks {
+		sink := &c.Sinks[i]
+
+		if sink.Name == "" {
+			sink.Name = sink.Type
+		}
+
+		if _, ok := sinkNames[sink.Name]; ok {
+			return fmt.Errorf("duplicate sink name '%s'", sink.Name)
+		}
+		sinkNames[sink.Name] = struct{}{}
+
+		if len(sink.Streams) == 0 {
+			sink.Streams = []string{StreamAudit, StreamNetwork}
+		}
+
+		for _, stream := range sink.Streams {
+			if stream != StreamAudit && stream != StreamNetwork {
+				return fmt.Errorf("sink '%s' has unknown stream '%s'", sink.Name, stream)
+			}
+		}
+
+		for _, secret := range sink.secrets() {
+			if err := resolveSecret(secret); err != nil {
+				return fmt.Errorf("sink '%s' has an invalid secret: %v", sink.Name, err)
+			}
+		}
+
+		switch sink.Type {
+		case SinkSplunk:
+			if sink.Splunk.URL == "" || sink.Splunk.Token == "" {
+				return errors.New("splunk sink requires a url and token")
+			}
+		case SinkElastic:
+			if sink.Elastic.URL == "" {
+				return errors.New("elastic sink requires a url")
+			}
+		case SinkFile:
+			if sink.File.Path == "" {
+				return errors.New("file sink requires a path")
+			}
+
+			if _, _, err := sink.File.Modes(); err != nil {
+				return fmt.Errorf("sink '%s': %v", sink.Name, err)
+			}
+		case SinkSyslog:
+			if sink.Syslog.Address == "" {
+				return errors.New("syslog sink requires an address")
+			}
+		case SinkS3:
+			if sink.S3.Endpoint == "" || sink.S3.Bucket == "" {
+				return errors.New("s3 sink requires an endpoint and bucket")
+			}
+		case SinkKafka:
+			if len(sink.Kafka.Brokers) == 0 {
+				return errors.New("kafka sink requires brokers")
+			}
+		}
+	}
+
+	return nil
+}
+
+// HasSink returns whether a sink of the given type is configured.
+func (c *Config) HasSink(sinkType string) bool {
+	for _, sink := range c.Sinks {
+		if sink.Type == sinkType {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,264 @@
+package deadletter
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+const (
+	recordsPrefix = "records-"
+	chunksPrefix  = "chunk-"
+	failedPrefix  = "failed-"
+
+	minBackoff = 5 * time.Minute
+	maxBackoff = 6 * time.Hour
+)
+
+// Store keeps data that could not be delivered on disk so it is not lost.
+type Store struct {
+	logger *logrus.Logger
+	dir    string
+}
+
+func New(logger *logrus.Logger, dir string) (*Store, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("nil logger provided")
+	}
+	if dir == "" {
+		return nil, fmt.Errorf("empty dead-letter directory provided")
+	}
+
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("could not create dead-letter directory '%s': %v", dir, err)
+	}
+
+	return &Store{
+		logger: logger,
+		dir:    dir,
+	}, nil
+}
+
+// writeFile atomically writes a JSON document into the store.
+func (s *Store) writeFile(prefix string, obj interface{}) (string, error) {
+	fileBytes, err := json.Marshal(obj)
+	if err != nil {
+		return "", fmt.Errorf("could not encode dead-letter entry: %v", err)
+	}
+
+	tmpFile, err := os.CreateTemp(s.dir, prefix+"*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("could not create dead-letter entry: %v", err)
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(fileBytes); err != nil {
+		_ = tmpFile.Close()
+		return "", fmt.Errorf("could not write dead-letter entry: %v", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return "", fmt.Errorf("could not close dead-letter entry: %v", err)
+	}
+
+	path := tmpFile.Name()[:len(tmpFile.Name())-len(".tmp")] + ".json"
+	if err := os.Rename(tmpFile.Name(), path); err != nil {
+		return "", fmt.Errorf("could not store dead-letter entry: %v", err)
+	}
+
+	return path, nil
+}
+
+// WriteRecords stores records of a stream that can not be shipped, together with the reason why.
+func (s *Store) WriteRecords(streamName, reason string, records []map[string]string) error {
+	path, err := s.writeFile(recordsPrefix+filepath.Base(streamName)+"-", &RecordSet{
+		StreamName: streamName,
+		Reason:     reason,
+		Created:    time.Now().UTC(),
+		Records:    records,
+	})
+	if err != nil {
+		return err
+	}
+
+	s.logger.WithField("module", "deadletter").WithField("stream_name", streamName).
+		WithField("records", len(records)).WithField("path", path).Warn("stored records in dead-letter store")
+
+	return nil
+}
+
+// RecordSet are records that were diverted before they were chunked, stored to be replayed later.
+type RecordSet struct {
+	// path is the file backing the record set, empty until it was read from the store
+	path string
+
+	StreamName string              `json:"stream_name"`
+	Reason     string              `json:"reason"`
+	Created    time.Time           `json:"created"`
+	Records    []map[string]string `json:"records"`
+}
+
+// RecordSets returns every stored record set, oldest first.
+func (s *Store) RecordSets() ([]*RecordSet, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, recordsPrefix+"*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead-letter records: %v", err)
+	}
+
+	recordSets := make([]*RecordSet, 0, len(paths))
+
+	for _, path := range paths {
+		recordsBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read dead-letter records '%s': %v", path, err)
+		}
+
+		var recordSet RecordSet
+		if err := json.Unmarshal(recordsBytes, &recordSet); err != nil {
+			return nil, fmt.Errorf("could not decode dead-letter records '%s': %v", path, err)
+		}
+
+		recordSet.path = path
+		recordSets = append(recordSets, &recordSet)
+	}
+
+	sort.Slice(recordSets, func(i, j int) bool {
+		return recordSets[i].Created.Before(recordSets[j].Created)
+	})
+
+	return recordSets, nil
+}
+
+// RemoveRecordSet deletes a record set that was replayed.
+func (s *Store) RemoveRecordSet(recordSet *RecordSet) error {
+	if recordSet.path == "" {
+		return nil
+	}
+
+	if err := os.Remove(recordSet.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove dead-letter records '%s': %v", recordSet.path, err)
+	}
+
+	recordSet.path = ""
+
+	return nil
+}
+
+// Chunk is an upload that failed, stored with everything needed to retry it later.
+type Chunk struct {
+	// path is the file backing the chunk, empty until it was written
+	path string
+
+	Endpoint    string    `json:"endpoint"`
+	RuleID      string    `json:"rule_id"`
+	StreamName  string    `json:"stream_name"`
+	Compressed  bool      `json:"compressed"`
+	Records     int       `json:"records"`
+	Attempts    int       `json:"attempts"`
+	LastError   string    `json:"last_error"`
+	Created     time.Time `json:"created"`
+	NextAttempt time.Time `json:"next_attempt"`
+	Payload     []byte    `json:"payload"`
+}
+
+// WriteChunk stores a new failed chunk or updates the metadata of one that was read from the store.
+func (s *Store) WriteChunk(chunk *Chunk) error {
+	return s.writeChunk(chunksPrefix, chunk, "stored chunk in dead-letter store")
+}
+
+// FailChunk moves a chunk aside that is never retried again, it is kept for manual inspection.
+func (s *Store) FailChunk(chunk *Chunk) error {
+	return s.writeChunk(failedPrefix, chunk, "gave up on dead-letter chunk")
+}
+
+func (s *Store) writeChunk(prefix string, chunk *Chunk, message string) error {
+	if chunk.Created.IsZero() {
+		chunk.Created = time.Now().UTC()
+	}
+
+	path, err := s.writeFile(prefix+filepath.Base(chunk.StreamName)+"-", chunk)
+	if err != nil {
+		return err
+	}
+
+	// the new file fully replaces the previous version of the chunk
+	if chunk.path != "" {
+		if err := os.Remove(chunk.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not replace dead-letter chunk '%s': %v", chunk.path, err)
+		}
+	}
+
+	chunk.path = path
+
+	s.logger.WithField("module", "deadletter").WithField("stream_name", chunk.StreamName).
+		WithField("records", chunk.Records).WithField("attempts", chunk.Attempts).WithField("path", path).
+		Warn(message)
+
+	return nil
+}
+
+// Chunks returns every stored chunk that is still retried, oldest first.
+func (s *Store) Chunks() ([]*Chunk, error) {
+	paths, err := filepath.Glob(filepath.Join(s.dir, chunksPrefix+"*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list dead-letter chunks: %v", err)
+	}
+
+	chunks := make([]*Chunk, 0, len(paths))
+
+	for _, path := range paths {
+		chunkBytes, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read dead-letter chunk '%s': %v", path, err)
+		}
+
+		var chunk Chunk
+		if err := json.Unmarshal(chunkBytes, &chunk); err != nil {
+			return nil, fmt.Errorf("could not decode dead-letter chunk '%s': %v", path, err)
+		}
+
+		chunk.path = path
+		chunks = append(chunks, &chunk)
+	}
+
+	sort.Slice(chunks, func(i, j int) bool {
+		return chunks[i].Created.Before(chunks[j].Created)
+	})
+
+	return chunks, nil
+}
+
+// RemoveChunk deletes a chunk that was delivered.
+func (s *Store) RemoveChunk(chunk *Chunk) error {
+	if chunk.path == "" {
+		return nil
+	}
+
+	if err := os.Remove(chunk.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("could not remove dead-letter chunk '%s': %v", chunk.path, err)
+	}
+
+	chunk.path = ""
+
+	return nil
+}
+
+// Backoff returns how long to wait before retrying a chunk that failed the given amount of times.
+func Backoff(attempts int) time.Duration {
+	if attempts <= 0 {
+		return 0
+	}
+
+	backoff := minBackoff
+	for i := 1; i < attempts && backoff < maxBackoff; i++ {
+		backoff *= 2
+	}
+
+	return min(backoff, maxBackoff)
+}
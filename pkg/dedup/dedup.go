@@ -0,0 +1,217 @@
+package dedup
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// eventIDField is the column every converted log carries to identify its source record
+	eventIDField = "EventId"
+
+	cacheVersion = 1
+)
+
+type cacheFile struct {
+	Version int              `json:"version"`
+	Entries map[string]int64 `json:"entries"`
+}
+
+// Cache remembers which events were shipped recently so overlapping lookback windows do not cause duplicates.
+type Cache struct {
+	logger *logrus.Logger
+
+	path       string
+	window     time.Duration
+	maxEntries int
+
+	lock    sync.Mutex
+	entries map[string]time.Time
+}
+
+func New(logger *logrus.Logger, path string, window time.Duration, maxEntries int) (*Cache, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("nil logger provided")
+	}
+	if path == "" {
+		return nil, fmt.Errorf("empty dedup cache path provided")
+	}
+	if window <= 0 {
+		return nil, fmt.Errorf("invalid dedup window provided: %s", window)
+	}
+	if maxEntries <= 0 {
+		return nil, fmt.Errorf("invalid dedup max entries provided: %d", maxEntries)
+	}
+
+	cache := Cache{
+		logger:     logger,
+		path:       path,
+		window:     window,
+		maxEntries: maxEntries,
+		entries:    make(map[string]time.Time),
+	}
+
+	if err := cache.load(); err != nil {
+		return nil, err
+	}
+
+	return &cache, nil
+}
+
+func (c *Cache) load() error {
+	logger := c.logger.WithField("module", "dedup")
+
+	cacheBytes, err := os.ReadFile(c.path)
+	if errors.Is(err, os.ErrNotExist) {
+		logger.WithField("path", c.path).Debug("no dedup cache found, starting empty")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("could not read dedup cache '%s': %v", c.path, err)
+	}
+
+	var file cacheFile
+	if err := json.Unmarshal(cacheBytes, &file); err != nil {
+		return fmt.Errorf("could not decode dedup cache '%s': %v", c.path, err)
+	}
+
+	if file.Version != cacheVersion {
+		logger.WithField("version", file.Version).Warn("ignoring dedup cache with unknown version")
+		return nil
+	}
+
+	for id, shipped := range file.Entries {
+		c.entries[id] = time.Unix(shipped, 0)
+	}
+
+	c.prune(time.Now())
+
+	logger.WithField("entries", len(c.entries)).Debug("loaded dedup cache")
+
+	return nil
+}
+
+// prune drops expired entries and evicts the oldest ones when the cache is over capacity.
+func (c *Cache) prune(now time.Time) {
+	cutoff := now.Add(-c.window)
+
+	for id, shipped := range c.entries {
+		if shipped.Before(cutoff) {
+			delete(c.entries, id)
+		}
+	}
+
+	if len(c.entries) <= c.maxEntries {
+		return
+	}
+
+	ids := make([]string, 0, len(c.entries))
+	for id := range c.entries {
+		ids = append(ids, id)
+	}
+
+	sort.Slice(ids, func(i, j int) bool {
+		return c.entries[ids[i]].Before(c.entries[ids[j]])
+	})
+
+	for _, id := range ids[:len(ids)-c.maxEntries] {
+		delete(c.entries, id)
+	}
+}
+
+// Filter returns the logs that were not shipped within the dedup window.
+func (c *Cache) Filter(logs []map[string]string) []map[string]string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	cutoff := time.Now().Add(-c.window)
+	output := make([]map[string]string, 0, len(logs))
+
+	for _, log := range logs {
+		id := log[eventIDField]
+		if id == "" {
+			output = append(output, log)
+			continue
+		}
+
+		if shipped, ok := c.entries[id]; ok && shipped.After(cutoff) {
+			continue
+		}
+
+		output = append(output, log)
+	}
+
+	c.logger.WithField("module", "dedup").
+		WithField("total", len(logs)).WithField("duplicates", len(logs)-len(output)).
+		Debug("filtered duplicate logs")
+
+	return output
+}
+
+// Mark records the logs as shipped.
+func (c *Cache) Mark(logs []map[string]string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := time.Now()
+
+	for _, log := range logs {
+		if id := log[eventIDField]; id != "" {
+			c.entries[id] = now
+		}
+	}
+}
+
+// Save prunes the cache and persists it to disk.
+func (c *Cache) Save() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.prune(time.Now())
+
+	file := cacheFile{
+		Version: cacheVersion,
+		Entries: make(map[string]int64, len(c.entries)),
+	}
+
+	for id, shipped := range c.entries {
+		file.Entries[id] = shipped.Unix()
+	}
+
+	cacheBytes, err := json.Marshal(&file)
+	if err != nil {
+		return fmt.Errorf("could not encode dedup cache: %v", err)
+	}
+
+	// write to a temporary file first so a crash never leaves a truncated cache behind
+	tmpFile, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("could not create temporary dedup cache: %v", err)
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.Write(cacheBytes); err != nil {
+		_ = tmpFile.Close()
+		return fmt.Errorf("could not write dedup cache: %v", err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return fmt.Errorf("could not close dedup cache: %v", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), c.path); err != nil {
+		return fmt.Errorf("could not replace dedup cache '%s': %v", c.path, err)
+	}
+
+	c.logger.WithField("module", "dedup").WithField("entries", len(c.entries)).Debug("saved dedup cache")
+
+	return nil
+}
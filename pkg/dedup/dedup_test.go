@@ -0,0 +1,156 @@
+package dedup
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func newTestCache(t *testing.T, path string, window time.Duration, maxEntries int) *Cache {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	cache, err := New(logger, path, window, maxEntries)
+	if err != nil {
+		t.Fatalf("could not create cache: %v", err)
+	}
+
+	return cache
+}
+
+func logs(ids ...string) []map[string]string {
+	output := make([]map[string]string, len(ids))
+	for i, id := range ids {
+		output[i] = map[string]string{eventIDField: id}
+	}
+
+	return output
+}
+
+func ids(logs []map[string]string) []string {
+	output := make([]string, len(logs))
+	for i, log := range logs {
+		output[i] = log[eventIDField]
+	}
+
+	return output
+}
+
+func TestRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+
+	cache := newTestCache(t, path, time.Hour, 100)
+
+	if filtered := cache.Filter(logs("a", "b")); len(filtered) != 2 {
+		t.Fatalf("an empty cache filtered %v", filtered)
+	}
+
+	cache.Mark(logs("a", "b"))
+
+	if err := cache.Save(); err != nil {
+		t.Fatalf("could not save cache: %v", err)
+	}
+
+	loaded := newTestCache(t, path, time.Hour, 100)
+
+	// logs without an event id can not be deduplicated and are always kept
+	filtered := ids(loaded.Filter(logs("a", "c", "b", "")))
+	if fmt.Sprint(filtered) != fmt.Sprint([]string{"c", ""}) {
+		t.Fatalf("loaded cache kept %v, expected [c ]", filtered)
+	}
+
+	tmpFiles, _ := filepath.Glob(filepath.Join(filepath.Dir(path), "*.tmp"))
+	if len(tmpFiles) != 0 {
+		t.Fatalf("temporary files were left behind: %v", tmpFiles)
+	}
+}
+
+func TestWindowExpiry(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+
+	cache := newTestCache(t, path, time.Hour, 100)
+
+	cache.entries["expired"] = time.Now().Add(-2 * time.Hour)
+	cache.entries["recent"] = time.Now().Add(-30 * time.Minute)
+
+	if filtered := ids(cache.Filter(logs("expired", "recent"))); fmt.Sprint(filtered) != "[expired]" {
+		t.Fatalf("cache kept %v, expected only the expired log", filtered)
+	}
+
+	if err := cache.Save(); err != nil {
+		t.Fatalf("could not save cache: %v", err)
+	}
+
+	loaded := newTestCache(t, path, time.Hour, 100)
+
+	if _, ok := loaded.entries["expired"]; ok {
+		t.Fatal("an expired entry was saved")
+	}
+
+	if _, ok := loaded.entries["recent"]; !ok {
+		t.Fatal("a recent entry was not saved")
+	}
+}
+
+func TestMaxEntriesEvictsOldest(t *testing.T) {
+	cache := newTestCache(t, filepath.Join(t.TempDir(), "dedup.json"), time.Hour, 3)
+
+	now := time.Now()
+	for i := range 5 {
+		cache.entries[fmt.Sprintf("event-%d", i)] = now.Add(time.Duration(i-5) * time.Minute)
+	}
+
+	if err := cache.Save(); err != nil {
+		t.Fatalf("could not save cache: %v", err)
+	}
+
+	if len(cache.entries) != 3 {
+		t.Fatalf("cache holds %d entries, expected 3", len(cache.entries))
+	}
+
+	for _, id := range []string{"event-2", "event-3", "event-4"} {
+		if _, ok := cache.entries[id]; !ok {
+			t.Errorf("newest entry %s was evicted", id)
+		}
+	}
+}
+
+func TestUnknownVersion(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+
+	shipped := time.Now().Unix()
+	if err := os.WriteFile(path, []byte(fmt.Sprintf(`{"version":99,"entries":{"a":%d}}`, shipped)), 0o600); err != nil {
+		t.Fatalf("could not write cache: %v", err)
+	}
+
+	cache := newTestCache(t, path, time.Hour, 100)
+
+	if len(cache.entries) != 0 {
+		t.Fatalf("loaded %d entries of an unknown version", len(cache.entries))
+	}
+
+	if filtered := cache.Filter(logs("a")); len(filtered) != 1 {
+		t.Fatal("an entry of an unknown version filtered a log")
+	}
+}
+
+func TestCorruptCache(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "dedup.json")
+
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatalf("could not write cache: %v", err)
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	if _, err := New(logger, path, time.Hour, 100); err == nil {
+		t.Fatal("expected a corrupt cache to fail")
+	}
+}
@@ -0,0 +1,154 @@
+package elastic
+
+import (
+	"encoding/json"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// document is an ECS document, nested by dotted field names.
+type document map[string]interface{}
+
+// set stores a value under a dotted ECS field name, creating the parent objects.
+func (d document) set(field string, value interface{}) {
+	parts := strings.Split(field, ".")
+
+	current := d
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := current[part].(document)
+		if !ok {
+			next = document{}
+			current[part] = next
+		}
+
+		current = next
+	}
+
+	current[parts[len(parts)-1]] = value
+}
+
+// setString only stores non-empty values, split continuation rows lack most columns.
+func (d document) setString(field, value string) {
+	if value != "" {
+		d.set(field, value)
+	}
+}
+
+// setInt stores a column holding an integer, values that do not parse are skipped.
+func (d document) setInt(field, value string) {
+	if n, err := strconv.ParseInt(value, 10, 64); err == nil {
+		d.set(field, n)
+	}
+}
+
+// setEndpoint splits an ip:port column into the ip and port fields of an ECS endpoint.
+func (d document) setEndpoint(prefix, value string) {
+	if value == "" {
+		return
+	}
+
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		d.set(prefix+".ip", value)
+		return
+	}
+
+	d.set(prefix+".ip", host)
+	d.setInt(prefix+".port", port)
+}
+
+// auditEventTypes maps tailscale audit actions to the ECS event type.
+var auditEventTypes = map[string]string{
+	"CREATE": "creation",
+	"DELETE": "deletion",
+}
+
+// toECS maps a converted row onto the Elastic Common Schema, tailscale specific columns are kept under tailscale.*.
+func toECS(stream sink.Stream, row map[string]string) document {
+	doc := document{}
+
+	doc.setString("@timestamp", row["TimeGenerated"])
+	doc.setString("event.id", row["EventId"])
+	doc.set("event.kind", "event")
+	doc.set("event.provider", "tailscale")
+
+	switch stream {
+	case sink.StreamAudit:
+		doc.set("event.dataset", "tailscale.audit")
+		doc.set("event.category", []string{"configuration"})
+		doc.setString("event.action", strings.ToLower(row["Action"]))
+
+		if row["Action"] != "" {
+			eventType, ok := auditEventTypes[strings.ToUpper(row["Action"])]
+			if !ok {
+				eventType = "change"
+			}
+			doc.set("event.type", []string{eventType})
+		}
+
+		var actor struct {
+			ID          string `json:"id"`
+			Type        string `json:"type"`
+			LoginName   string `json:"loginName"`
+			DisplayName string `json:"displayName"`
+		}
+		if json.Unmarshal([]byte(row["Actor"]), &actor) == nil {
+			doc.setString("user.id", actor.ID)
+			doc.setString("user.name", actor.LoginName)
+			doc.setString("user.full_name", actor.DisplayName)
+			doc.setString("tailscale.audit.actor.type", actor.Type)
+		}
+
+		var target struct {
+			ID       string `json:"id"`
+			Name     string `json:"name"`
+			Type     string `json:"type"`
+			Property string `json:"property"`
+		}
+		if json.Unmarshal([]byte(row["Target"]), &target) == nil {
+			doc.setString("tailscale.audit.target.id", target.ID)
+			doc.setString("tailscale.audit.target.name", target.Name)
+			doc.setString("tailscale.audit.target.type", target.Type)
+			doc.setString("tailscale.audit.target.property", target.Property)
+
+			if strings.EqualFold(target.Type, "USER") {
+				doc.setString("user.target.id", target.ID)
+				doc.setString("user.target.name", target.Name)
+			}
+		}
+
+		doc.setString("tailscale.audit.type", row["ActionType"])
+		doc.setString("tailscale.audit.origin", row["Origin"])
+		doc.setString("tailscale.audit.old", row["Old"])
+		doc.setString("tailscale.audit.new", row["New"])
+		doc.setString("tailscale.audit.changes", row["Changes"])
+
+	case sink.StreamNetwork:
+		doc.set("event.dataset", "tailscale.network")
+		doc.set("event.category", []string{"network"})
+		doc.set("event.type", []string{"connection"})
+		doc.setString("event.start", row["Start"])
+		doc.setString("event.end", row["End"])
+
+		doc.setString("host.id", row["NodeID"])
+		doc.setEndpoint("source", row["Src"])
+		doc.setEndpoint("destination", row["Dst"])
+		doc.setString("network.transport", strings.ToLower(row["Protocol"]))
+		doc.setInt("network.bytes", row["Bytes"])
+		doc.setInt("network.packets", row["Packets"])
+
+		for _, endpoint := range []struct{ column, field string }{{"Src", "source"}, {"Dst", "destination"}} {
+			doc.setString(endpoint.field+".geo.country_iso_code", row[endpoint.column+"Country"])
+			doc.setString(endpoint.field+".geo.city_name", row[endpoint.column+"City"])
+			doc.setInt(endpoint.field+".as.number", row[endpoint.column+"ASN"])
+			doc.setString(endpoint.field+".as.organization.name", row[endpoint.column+"ASOrg"])
+		}
+
+		doc.setString("tailscale.network.traffic_type", row["TrafficType"])
+		doc.setInt("tailscale.network.index", row["Index"])
+	}
+
+	return doc
+}
@@ -0,0 +1,422 @@
+package elastic
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultMaxBatchBytes = 5 * 1000 * 1000 // 5MB
+
+	// maxItemRetries is how often items rejected with a retryable status are resent
+	maxItemRetries = 3
+	retryBackoff   = time.Second
+)
+
+var (
+	defaultIndexes = map[sink.Stream]string{
+		sink.StreamAudit:   "tail2sen-audit",
+		sink.StreamNetwork: "tail2sen-network",
+	}
+
+	// retryableStatuses are item statuses caused by load rather than by the document
+	retryableStatuses = map[int]struct{}{
+		http.StatusTooManyRequests:    {},
+		http.StatusServiceUnavailable: {},
+		http.StatusGatewayTimeout:     {},
+	}
+)
+
+// StreamOptions decides where the documents of a stream are written to.
+type StreamOptions struct {
+	// Index is the index or data stream name.
+	Index      string
+	DataStream bool
+}
+
+type Options struct {
+	// URL is the base url of the cluster, e.g. https://elastic:9200.
+	URL string
+	// Username and Password use basic authentication, APIKey is the base64 encoded id:key of an API key.
+	Username string
+	Password string
+	APIKey   string
+	TLS      utils.TLSOptions
+
+	// MaxBatchBytes bounds the size of a single bulk request, defaults to 5MB.
+	MaxBatchBytes int
+	// InstallTemplates creates or updates the index template of every stream before its first write.
+	InstallTemplates bool
+
+	Streams map[sink.Stream]StreamOptions
+}
+
+// Elastic is a sink that indexes rows as ECS documents through the bulk API of Elasticsearch or OpenSearch.
+type Elastic struct {
+	name       string
+	logger     *logrus.Logger
+	options    Options
+	httpClient *http.Client
+
+	templatesLock sync.Mutex
+	templates     map[sink.Stream]bool
+}
+
+type bulkItemResult struct {
+	Status int `json:"status"`
+	Error  *struct {
+		Type   string `json:"type"`
+		Reason string `json:"reason"`
+	} `json:"error"`
+}
+
+type bulkResponse struct {
+	Errors bool                        `json:"errors"`
+	Items  []map[string]bulkItemResult `json:"items"`
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*Elastic, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("no elastic url provided")
+	}
+
+	options.URL = strings.TrimSuffix(options.URL, "/")
+
+	if options.MaxBatchBytes <= 0 {
+		options.MaxBatchBytes = defaultMaxBatchBytes
+	}
+
+	streams := make(map[sink.Stream]StreamOptions, len(defaultIndexes))
+	for stream, index := range defaultIndexes {
+		streamOptions := options.Streams[stream]
+		if streamOptions.Index == "" {
+			streamOptions.Index = index
+		}
+
+		streams[stream] = streamOptions
+	}
+	options.Streams = streams
+
+	tlsConfig, err := options.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Elastic{
+		name:       name,
+		logger:     logger,
+		options:    options,
+		httpClient: utils.NewLogHttpClientWithTLS(logger, tlsConfig),
+		templates:  make(map[sink.Stream]bool),
+	}, nil
+}
+
+func (e *Elastic) Name() string {
+	return e.name
+}
+
+// request sends a request to the cluster and decodes the json response into out.
+func (e *Elastic) request(ctx context.Context, method, path, contentType string, body []byte, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, e.options.URL+path, reader)
+	if err != nil {
+		return fmt.Errorf("could not create request: %v", err)
+	}
+
+	if body != nil {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	if e.options.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+e.options.APIKey)
+	} else if e.options.Username != "" {
+		req.SetBasicAuth(e.options.Username, e.options.Password)
+	}
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("bad http response: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response: %v", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("received error code %d: %s", resp.StatusCode, string(respBytes))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBytes, out); err != nil {
+			return fmt.Errorf("could not decode response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// installTemplate creates or updates the index template of a stream once per run.
+func (e *Elastic) installTemplate(ctx context.Context, stream sink.Stream) error {
+	e.templatesLock.Lock()
+	defer e.templatesLock.Unlock()
+
+	if e.templates[stream] {
+		return nil
+	}
+
+	options := e.options.Streams[stream]
+
+	templateBytes, err := json.Marshal(indexTemplate(stream, options))
+	if err != nil {
+		return fmt.Errorf("could not encode index template: %v", err)
+	}
+
+	templateName := url.PathEscape(options.Index)
+
+	if err := e.request(ctx, http.MethodPut, "/_index_template/"+templateName, "application/json", templateBytes, nil); err != nil {
+		return fmt.Errorf("could not install index template '%s': %v", options.Index, err)
+	}
+
+	e.logger.WithField("module", "elastic").WithField("sink", e.name).WithField("index", options.Index).
+		Info("installed index template")
+
+	e.templates[stream] = true
+
+	return nil
+}
+
+// documentID keeps writes idempotent, the event id is unique per row.
+func documentID(row map[string]string) string {
+	return row["EventId"]
+}
+
+// item is a single encoded bulk operation, id is the document id it was created with.
+type item struct {
+	id      string
+	payload []byte
+}
+
+func (e *Elastic) encodeItems(stream sink.Stream, rows []map[string]string) ([]item, error) {
+	index := e.options.Streams[stream].Index
+	items := make([]item, len(rows))
+
+	for i, row := range rows {
+		// create is the only operation data streams accept, and turns a duplicate id into a harmless conflict
+		id := documentID(row)
+
+		action := map[string]map[string]string{"create": {"_index": index}}
+		if id != "" {
+			action["create"]["_id"] = id
+		}
+
+		actionBytes, err := json.Marshal(action)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode bulk action: %v", err)
+		}
+
+		docBytes, err := json.Marshal(toECS(stream, row))
+		if err != nil {
+			return nil, fmt.Errorf("could not encode document: %v", err)
+		}
+
+		payload := make([]byte, 0, len(actionBytes)+len(docBytes)+2)
+		payload = append(payload, actionBytes...)
+		payload = append(payload, '\n')
+		payload = append(payload, docBytes...)
+		payload = append(payload, '\n')
+
+		items[i] = item{id: id, payload: payload}
+	}
+
+	return items, nil
+}
+
+// bulk sends the items in requests under the maximum batch size and returns the error of every item, nil on success.
+func (e *Elastic) bulk(ctx context.Context, items []item, indexes []int) ([]error, error) {
+	itemErrs := make([]error, len(items))
+
+	for start := 0; start < len(indexes); {
+		var body bytes.Buffer
+		end := start
+
+		for end < len(indexes) && (end == start || body.Len()+len(items[indexes[end]].payload) <= e.options.MaxBatchBytes) {
+			body.Write(items[indexes[end]].payload)
+			end++
+		}
+
+		var resp bulkResponse
+		if err := e.request(ctx, http.MethodPost, "/_bulk", "application/x-ndjson", body.Bytes(), &resp); err != nil {
+			return nil, fmt.Errorf("could not send bulk request: %v", err)
+		}
+
+		if len(resp.Items) != end-start {
+			return nil, fmt.Errorf("bulk response has %d items instead of %d", len(resp.Items), end-start)
+		}
+
+		for i, result := range resp.Items {
+			index := indexes[start+i]
+
+			for _, operation := range result {
+				if operation.Status >= 200 && operation.Status < 300 {
+					continue
+				}
+
+				// a conflict on the id of the row means the same row was indexed by an earlier run
+				if operation.Status == http.StatusConflict && items[index].id != "" {
+					continue
+				}
+
+				reason := http.StatusText(operation.Status)
+				if operation.Error != nil {
+					reason = fmt.Sprintf("%s: %s", operation.Error.Type, operation.Error.Reason)
+				}
+
+				itemErrs[index] = &itemError{status: operation.Status, reason: reason}
+			}
+		}
+
+		start = end
+	}
+
+	return itemErrs, nil
+}
+
+type itemError struct {
+	status int
+	reason string
+}
+
+func (e *itemError) Error() string {
+	return fmt.Sprintf("status %d: %s", e.status, e.reason)
+}
+
+// Write indexes the rows, resending items that were rejected because the cluster was overloaded.
+// It returns how many leading rows were indexed.
+func (e *Elastic) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	logger := e.logger.WithField("module", "elastic").WithField("sink", e.name).WithField("stream", stream)
+
+	if e.options.InstallTemplates {
+		if err := e.installTemplate(ctx, stream); err != nil {
+			return 0, err
+		}
+	}
+
+	items, err := e.encodeItems(stream, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.WithField("total", len(rows)).WithField("index", e.options.Streams[stream].Index).Info("indexing documents")
+
+	itemErrs := make([]error, len(items))
+
+	pending := make([]int, len(items))
+	for i := range pending {
+		pending[i] = i
+	}
+
+	for attempt := 0; len(pending) > 0; attempt++ {
+		if attempt > 0 {
+			logger.WithField("retry", attempt).WithField("items", len(pending)).Warn("retrying rejected documents")
+
+			select {
+			case <-ctx.Done():
+				return shippedPrefix(itemErrs, pending), ctx.Err()
+			case <-time.After(retryBackoff << (attempt - 1)):
+			}
+		}
+
+		results, err := e.bulk(ctx, items, pending)
+		if err != nil {
+			return shippedPrefix(itemErrs, pending), err
+		}
+
+		var retry []int
+		for _, i := range pending {
+			itemErrs[i] = results[i]
+
+			if itemErr, ok := results[i].(*itemError); ok && attempt < maxItemRetries {
+				if _, retryable := retryableStatuses[itemErr.status]; retryable {
+					retry = append(retry, i)
+				}
+			}
+		}
+
+		pending = retry
+	}
+
+	shipped := shippedPrefix(itemErrs, nil)
+
+	failed := 0
+	var firstErr error
+	for _, itemErr := range itemErrs {
+		if itemErr != nil {
+			if firstErr == nil {
+				firstErr = itemErr
+			}
+			failed++
+		}
+	}
+
+	if failed > 0 {
+		return shipped, fmt.Errorf("%d/%d documents were rejected, first: %v", failed, len(items), firstErr)
+	}
+
+	logger.WithField("total", shipped).Info("indexed documents")
+
+	return shipped, nil
+}
+
+// shippedPrefix returns the amount of leading items that were indexed, pending items count as not indexed.
+func shippedPrefix(itemErrs []error, pending []int) int {
+	first := len(itemErrs)
+	for i, itemErr := range itemErrs {
+		if itemErr != nil {
+			first = i
+			break
+		}
+	}
+
+	for _, i := range pending {
+		first = min(first, i)
+	}
+
+	return first
+}
+
+// Flush is a no-op since the bulk API only responds once the documents were indexed.
+func (e *Elastic) Flush(_ context.Context) error {
+	return nil
+}
+
+func (e *Elastic) Close() error {
+	e.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Health checks the cluster is reachable with the configured credentials.
+func (e *Elastic) Health(ctx context.Context) error {
+	if err := e.request(ctx, http.MethodGet, "/", "", nil, nil); err != nil {
+		return fmt.Errorf("elastic cluster is unhealthy: %v", err)
+	}
+
+	return nil
+}
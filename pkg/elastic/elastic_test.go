@@ -0,0 +1,280 @@
+package elastic
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/sink/sinktest"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// bulkOperation is an action and document received by the fake bulk API.
+type bulkOperation struct {
+	Action   map[string]map[string]string
+	Document map[string]interface{}
+}
+
+// fakeBulk is a bulk API that answers every item with the status returned by status.
+type fakeBulk struct {
+	t *testing.T
+
+	lock     sync.Mutex
+	requests [][]bulkOperation
+	attempts map[string]int
+	headers  []http.Header
+
+	// status decides the status of an item by its document id and how often it was received before
+	status func(id string, attempt int) int
+}
+
+func (f *fakeBulk) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	if r.URL.Path != "/_bulk" {
+		w.WriteHeader(http.StatusOK)
+		_, _ = w.Write([]byte(`{}`))
+		return
+	}
+
+	var operations []bulkOperation
+
+	scanner := bufio.NewScanner(r.Body)
+	scanner.Buffer(nil, 10*1000*1000)
+
+	for scanner.Scan() {
+		var operation bulkOperation
+		if err := json.Unmarshal(scanner.Bytes(), &operation.Action); err != nil {
+			f.t.Errorf("could not decode bulk action: %v", err)
+		}
+
+		if !scanner.Scan() {
+			f.t.Error("bulk action without a document")
+			break
+		}
+
+		if err := json.Unmarshal(scanner.Bytes(), &operation.Document); err != nil {
+			f.t.Errorf("could not decode bulk document: %v", err)
+		}
+
+		operations = append(operations, operation)
+	}
+
+	f.requests = append(f.requests, operations)
+	f.headers = append(f.headers, r.Header.Clone())
+
+	resp := bulkResponse{}
+
+	for _, operation := range operations {
+		id := operation.Action["create"]["_id"]
+
+		status := http.StatusCreated
+		if f.status != nil {
+			status = f.status(id, f.attempts[id])
+		}
+		f.attempts[id]++
+
+		result := bulkItemResult{Status: status}
+		if status >= 300 {
+			resp.Errors = true
+			result.Error = &struct {
+				Type   string `json:"type"`
+				Reason string `json:"reason"`
+			}{Type: "test_exception", Reason: fmt.Sprintf("status %d for %s", status, id)}
+		}
+
+		resp.Items = append(resp.Items, map[string]bulkItemResult{"create": result})
+	}
+
+	_ = json.NewEncoder(w).Encode(&resp)
+}
+
+func newTestElastic(t *testing.T, bulk *fakeBulk, options Options) *Elastic {
+	t.Helper()
+
+	bulk.t = t
+	bulk.attempts = make(map[string]int)
+
+	server := httptest.NewServer(bulk)
+	t.Cleanup(server.Close)
+
+	options.URL = server.URL
+
+	elastic, err := New(sinktest.Logger(), "elastic", options)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	return elastic
+}
+
+func testRows(n int) []map[string]string {
+	return sinktest.Rows(0, n, map[string]string{
+		"NodeID":      "node",
+		"TrafficType": "virtual",
+		"Protocol":    "tcp",
+		"Src":         "100.64.0.1:1234",
+		"Dst":         "100.64.0.2:443",
+		"Bytes":       "100",
+		"Packets":     "1",
+	})
+}
+
+func TestWriteIndexesDocuments(t *testing.T) {
+	bulk := &fakeBulk{}
+
+	elastic := newTestElastic(t, bulk, Options{APIKey: "a2V5", MaxBatchBytes: 2000})
+
+	rows := testRows(10)
+
+	written, err := elastic.Write(context.Background(), sink.StreamNetwork, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	if len(bulk.requests) < 2 {
+		t.Fatalf("expected the rows to be split over several bulk requests, got %d", len(bulk.requests))
+	}
+
+	if got := bulk.headers[0].Get("Authorization"); got != "ApiKey a2V5" {
+		t.Errorf("unexpected authorization header '%s'", got)
+	}
+
+	received := 0
+	for _, operations := range bulk.requests {
+		for _, operation := range operations {
+			create, ok := operation.Action["create"]
+			if !ok {
+				t.Fatalf("expected a create action, got %v", operation.Action)
+			}
+
+			if create["_index"] != defaultIndexes[sink.StreamNetwork] {
+				t.Errorf("document written to index '%s'", create["_index"])
+			}
+
+			expectedID := fmt.Sprintf("event-%d", received)
+			if create["_id"] != expectedID {
+				t.Errorf("document has id '%s', expected '%s'", create["_id"], expectedID)
+			}
+
+			event, _ := operation.Document["event"].(map[string]interface{})
+			if event["id"] != expectedID {
+				t.Errorf("document has event.id '%v', expected '%s'", event["id"], expectedID)
+			}
+
+			received++
+		}
+	}
+
+	if received != len(rows) {
+		t.Fatalf("received %d documents, expected %d", received, len(rows))
+	}
+}
+
+func TestWriteReturnsPrefixOnItemFailure(t *testing.T) {
+	bulk := &fakeBulk{status: func(id string, _ int) int {
+		if id == "event-3" {
+			return http.StatusBadRequest
+		}
+		return http.StatusCreated
+	}}
+
+	elastic := newTestElastic(t, bulk, Options{})
+
+	written, err := elastic.Write(context.Background(), sink.StreamNetwork, testRows(6))
+	if err == nil {
+		t.Fatal("expected the rejected document to fail the write")
+	}
+
+	if !strings.Contains(err.Error(), "1/6 documents were rejected") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if written != 3 {
+		t.Fatalf("wrote %d rows, expected the 3 rows before the rejected one", written)
+	}
+
+	// a rejected document is not retried
+	if len(bulk.requests) != 1 {
+		t.Fatalf("expected a single bulk request, got %d", len(bulk.requests))
+	}
+}
+
+func TestWriteRetriesOverloadedItems(t *testing.T) {
+	bulk := &fakeBulk{status: func(id string, attempt int) int {
+		if id == "event-1" && attempt == 0 {
+			return http.StatusTooManyRequests
+		}
+		return http.StatusCreated
+	}}
+
+	elastic := newTestElastic(t, bulk, Options{})
+
+	rows := testRows(4)
+
+	written, err := elastic.Write(context.Background(), sink.StreamNetwork, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	if len(bulk.requests) != 2 {
+		t.Fatalf("expected the rejected document to be resent once, got %d requests", len(bulk.requests))
+	}
+
+	if retried := bulk.requests[1]; len(retried) != 1 || retried[0].Action["create"]["_id"] != "event-1" {
+		t.Fatalf("expected only the rejected document to be resent, got %v", retried)
+	}
+}
+
+func TestWriteConflicts(t *testing.T) {
+	conflict := func(id string, _ int) int {
+		if id == "" || id == "event-0" {
+			return http.StatusConflict
+		}
+		return http.StatusCreated
+	}
+
+	t.Run("document id", func(t *testing.T) {
+		elastic := newTestElastic(t, &fakeBulk{status: conflict}, Options{})
+
+		// the row was indexed by an earlier run
+		written, err := elastic.Write(context.Background(), sink.StreamNetwork, testRows(3))
+		if err != nil {
+			t.Fatalf("expected a conflict on the row id to count as indexed: %v", err)
+		}
+
+		if written != 3 {
+			t.Fatalf("wrote %d rows, expected 3", written)
+		}
+	})
+
+	t.Run("without document id", func(t *testing.T) {
+		elastic := newTestElastic(t, &fakeBulk{status: conflict}, Options{})
+
+		rows := testRows(3)
+		delete(rows[0], "EventId")
+
+		written, err := elastic.Write(context.Background(), sink.StreamNetwork, rows)
+		if err == nil {
+			t.Fatal("expected a conflict without a row id to fail")
+		}
+
+		if written != 0 {
+			t.Fatalf("wrote %d rows, expected none", written)
+		}
+	})
+}
@@ -0,0 +1,116 @@
+package elastic
+
+import (
+	"github.com/hazcod/tail2sen/pkg/sink"
+)
+
+type mapping map[string]interface{}
+
+func field(fieldType string) mapping {
+	return mapping{"type": fieldType}
+}
+
+func object(properties mapping) mapping {
+	return mapping{"properties": properties}
+}
+
+// endpointMapping are the ECS fields of a source or destination.
+func endpointMapping() mapping {
+	return object(mapping{
+		"ip":   field("ip"),
+		"port": field("long"),
+		"geo": object(mapping{
+			"country_iso_code": field("keyword"),
+			"city_name":        field("keyword"),
+		}),
+		"as": object(mapping{
+			"number":       field("long"),
+			"organization": object(mapping{"name": field("keyword")}),
+		}),
+	})
+}
+
+// streamMappings are the explicit mappings of the fields written by toECS, other fields are mapped dynamically.
+func streamMappings(stream sink.Stream) mapping {
+	properties := mapping{
+		"@timestamp": field("date"),
+		"event": object(mapping{
+			"id":       field("keyword"),
+			"kind":     field("keyword"),
+			"provider": field("keyword"),
+			"dataset":  field("keyword"),
+			"category": field("keyword"),
+			"type":     field("keyword"),
+			"action":   field("keyword"),
+			"start":    field("date"),
+			"end":      field("date"),
+		}),
+	}
+
+	switch stream {
+	case sink.StreamAudit:
+		properties["user"] = object(mapping{
+			"id":        field("keyword"),
+			"name":      field("keyword"),
+			"full_name": field("keyword"),
+			"target": object(mapping{
+				"id":   field("keyword"),
+				"name": field("keyword"),
+			}),
+		})
+		properties["tailscale"] = object(mapping{
+			"audit": object(mapping{
+				"type":   field("keyword"),
+				"origin": field("keyword"),
+				"actor":  object(mapping{"type": field("keyword")}),
+				"target": object(mapping{
+					"id":       field("keyword"),
+					"name":     field("keyword"),
+					"type":     field("keyword"),
+					"property": field("keyword"),
+				}),
+				// old and new values differ per action, so they are kept as the JSON text
+				"old":     field("text"),
+				"new":     field("text"),
+				"changes": field("text"),
+			}),
+		})
+
+	case sink.StreamNetwork:
+		properties["host"] = object(mapping{"id": field("keyword")})
+		properties["source"] = endpointMapping()
+		properties["destination"] = endpointMapping()
+		properties["network"] = object(mapping{
+			"transport": field("keyword"),
+			"bytes":     field("long"),
+			"packets":   field("long"),
+		})
+		properties["tailscale"] = object(mapping{
+			"network": object(mapping{
+				"traffic_type": field("keyword"),
+				"index":        field("integer"),
+			}),
+		})
+	}
+
+	return mapping{"properties": properties}
+}
+
+// indexTemplate is a composable index template for the index or data stream of a stream.
+func indexTemplate(stream sink.Stream, options StreamOptions) mapping {
+	template := mapping{
+		"index_patterns": []string{options.Index},
+		// above the priority of the built-in logs-*-* template
+		"priority": 200,
+		"template": mapping{
+			"mappings": streamMappings(stream),
+		},
+		"_meta": mapping{"managed_by": "tail2sen"},
+	}
+
+	if options.DataStream {
+		template["data_stream"] = mapping{}
+	}
+
+	return template
+}
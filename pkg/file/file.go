@@ -0,0 +1,478 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	activeSuffix     = ".ndjson"
+	compressedSuffix = ".gz"
+	// rotatedTimeFormat sorts lexically in the order the files were rotated
+	rotatedTimeFormat = "20060102T150405.000000000Z"
+
+	defaultMaxBytes = 100 * 1000 * 1000 // 100MB
+	// defaultFileMode and defaultDirMode let a log shipper in the same group read the files
+	defaultFileMode os.FileMode = 0o640
+	defaultDirMode  os.FileMode = 0o750
+)
+
+var (
+	defaultNames = map[sink.Stream]string{
+		sink.StreamAudit:   "tail2sen-audit",
+		sink.StreamNetwork: "tail2sen-network",
+	}
+)
+
+// StreamOptions decides the file a stream is written to.
+type StreamOptions struct {
+	// Name is the base name of the files, the active file is <name>.ndjson.
+	Name string
+}
+
+type Options struct {
+	// Dir is the directory the files are written to.
+	Dir string
+	// MaxBytes rotates the active file before it grows past this size, defaults to 100MB.
+	MaxBytes int64
+	// RotateInterval rotates the active file once it holds rows of an earlier interval, e.g. every hour.
+	RotateInterval time.Duration
+	// Compress gzips the files on rotation.
+	Compress bool
+	// MaxFiles is the amount of rotated files kept per stream, all files are kept when zero.
+	MaxFiles int
+	// FileMode is the mode of the written files, defaults to 0640.
+	FileMode os.FileMode
+	// DirMode is the mode of the directory when it is created, defaults to 0750.
+	DirMode os.FileMode
+
+	Streams map[sink.Stream]StreamOptions
+}
+
+// File is a sink that appends rows as NDJSON to a rotated file per stream.
+// The active file keeps a stable name and is rotated by renaming it, which is what log tailers expect.
+type File struct {
+	name    string
+	logger  *logrus.Logger
+	options Options
+
+	lock  sync.Mutex
+	files map[sink.Stream]*activeFile
+}
+
+// rotatedFile is a file a stream was rotated to.
+type rotatedFile struct {
+	path    string
+	rotated time.Time
+}
+
+// activeFile is the open file a stream is appended to.
+type activeFile struct {
+	path    string
+	file    *os.File
+	writer  *bufio.Writer
+	size    int64
+	modTime time.Time
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*File, error) {
+	if options.Dir == "" {
+		return nil, fmt.Errorf("empty file sink directory provided")
+	}
+
+	if options.MaxBytes <= 0 {
+		options.MaxBytes = defaultMaxBytes
+	}
+
+	if options.FileMode == 0 {
+		options.FileMode = defaultFileMode
+	}
+
+	if options.DirMode == 0 {
+		options.DirMode = defaultDirMode
+	}
+
+	streams := make(map[sink.Stream]StreamOptions, len(defaultNames))
+	for stream, defaultName := range defaultNames {
+		streamOptions := options.Streams[stream]
+		if streamOptions.Name == "" {
+			streamOptions.Name = defaultName
+		}
+
+		streams[stream] = streamOptions
+	}
+	options.Streams = streams
+
+	if _, err := os.Stat(options.Dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(options.Dir, options.DirMode); err != nil {
+			return nil, fmt.Errorf("could not create file sink directory '%s': %v", options.Dir, err)
+		}
+
+		// the umask applies to MkdirAll, so set the configured mode explicitly
+		if err := os.Chmod(options.Dir, options.DirMode); err != nil {
+			return nil, fmt.Errorf("could not set mode of file sink directory '%s': %v", options.Dir, err)
+		}
+	}
+
+	return &File{
+		name:    name,
+		logger:  logger,
+		options: options,
+		files:   make(map[sink.Stream]*activeFile),
+	}, nil
+}
+
+func (f *File) Name() string {
+	return f.name
+}
+
+// open returns the active file of a stream, opening it for appending if needed.
+func (f *File) open(stream sink.Stream) (*activeFile, error) {
+	if active, ok := f.files[stream]; ok {
+		return active, nil
+	}
+
+	path := filepath.Join(f.options.Dir, f.options.Streams[stream].Name+activeSuffix)
+
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, f.options.FileMode)
+	if err != nil {
+		return nil, fmt.Errorf("could not open '%s': %v", path, err)
+	}
+
+	if err := file.Chmod(f.options.FileMode); err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("could not set mode of '%s': %v", path, err)
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		_ = file.Close()
+		return nil, fmt.Errorf("could not stat '%s': %v", path, err)
+	}
+
+	active := &activeFile{
+		path:    path,
+		file:    file,
+		writer:  bufio.NewWriter(file),
+		size:    info.Size(),
+		modTime: info.ModTime(),
+	}
+
+	f.files[stream] = active
+
+	return active, nil
+}
+
+// close flushes and closes the active file of a stream.
+func (f *File) close(stream sink.Stream) error {
+	active, ok := f.files[stream]
+	if !ok {
+		return nil
+	}
+
+	delete(f.files, stream)
+
+	if err := active.writer.Flush(); err != nil {
+		_ = active.file.Close()
+		return fmt.Errorf("could not write '%s': %v", active.path, err)
+	}
+
+	if err := active.file.Sync(); err != nil {
+		_ = active.file.Close()
+		return fmt.Errorf("could not sync '%s': %v", active.path, err)
+	}
+
+	if err := active.file.Close(); err != nil {
+		return fmt.Errorf("could not close '%s': %v", active.path, err)
+	}
+
+	return nil
+}
+
+// needsRotation returns whether a line of the given size can not be appended to the active file.
+func (f *File) needsRotation(active *activeFile, lineSize int, now time.Time) bool {
+	if active.size == 0 {
+		return false
+	}
+
+	if active.size+int64(lineSize) > f.options.MaxBytes {
+		return true
+	}
+
+	return f.options.RotateInterval > 0 && active.modTime.Truncate(f.options.RotateInterval).Before(now.Truncate(f.options.RotateInterval))
+}
+
+// rotate moves the active file of a stream aside, compresses it if configured and removes the oldest rotated files.
+func (f *File) rotate(stream sink.Stream, now time.Time) error {
+	logger := f.logger.WithField("module", "file").WithField("sink", f.name).WithField("stream", stream)
+
+	if err := f.close(stream); err != nil {
+		return err
+	}
+
+	name := f.options.Streams[stream].Name
+	activePath := filepath.Join(f.options.Dir, name+activeSuffix)
+
+	rotated, err := f.rotatedFiles(name)
+	if err != nil {
+		return err
+	}
+
+	// a batch can rotate several times at once, so keep the timestamp after the newest rotated file
+	rotatedTime := now.UTC()
+	if len(rotated) > 0 {
+		if newest := rotated[len(rotated)-1].rotated; !rotatedTime.After(newest) {
+			rotatedTime = newest.Add(time.Nanosecond)
+		}
+	}
+
+	rotatedPath := filepath.Join(f.options.Dir, name+"-"+rotatedTime.Format(rotatedTimeFormat)+activeSuffix)
+
+	if err := os.Rename(activePath, rotatedPath); err != nil {
+		return fmt.Errorf("could not rotate '%s': %v", activePath, err)
+	}
+
+	if f.options.Compress {
+		compressedPath, err := compressFile(rotatedPath, f.options.FileMode)
+		if err != nil {
+			return err
+		}
+
+		rotatedPath = compressedPath
+	}
+
+	logger.WithField("path", rotatedPath).Info("rotated file")
+
+	return f.removeOldFiles(name)
+}
+
+// compressFile gzips a file next to it and removes the original.
+func compressFile(path string, mode os.FileMode) (string, error) {
+	compressedPath := path + compressedSuffix
+
+	src, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("could not open '%s': %v", path, err)
+	}
+
+	defer src.Close()
+
+	tmpFile, err := os.CreateTemp(filepath.Dir(path), filepath.Base(compressedPath)+"*.tmp")
+	if err != nil {
+		return "", fmt.Errorf("could not create compressed file: %v", err)
+	}
+
+	defer os.Remove(tmpFile.Name())
+
+	if err := tmpFile.Chmod(mode); err != nil {
+		_ = tmpFile.Close()
+		return "", fmt.Errorf("could not set mode of compressed file: %v", err)
+	}
+
+	gzipWriter := gzip.NewWriter(tmpFile)
+
+	if _, err := io.Copy(gzipWriter, src); err != nil {
+		_ = tmpFile.Close()
+		return "", fmt.Errorf("could not compress '%s': %v", path, err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		_ = tmpFile.Close()
+		return "", fmt.Errorf("could not compress '%s': %v", path, err)
+	}
+
+	if err := tmpFile.Close(); err != nil {
+		return "", fmt.Errorf("could not close compressed file: %v", err)
+	}
+
+	if err := os.Rename(tmpFile.Name(), compressedPath); err != nil {
+		return "", fmt.Errorf("could not store compressed file '%s': %v", compressedPath, err)
+	}
+
+	if err := os.Remove(path); err != nil {
+		return "", fmt.Errorf("could not remove '%s': %v", path, err)
+	}
+
+	return compressedPath, nil
+}
+
+// removeOldFiles keeps only the newest rotated files of a stream.
+func (f *File) removeOldFiles(name string) error {
+	if f.options.MaxFiles <= 0 {
+		return nil
+	}
+
+	rotated, err := f.rotatedFiles(name)
+	if err != nil {
+		return err
+	}
+
+	if len(rotated) <= f.options.MaxFiles {
+		return nil
+	}
+
+	for _, file := range rotated[:len(rotated)-f.options.MaxFiles] {
+		if err := os.Remove(file.path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return fmt.Errorf("could not remove rotated file '%s': %v", file.path, err)
+		}
+
+		f.logger.WithField("module", "file").WithField("sink", f.name).WithField("path", file.path).Debug("removed rotated file")
+	}
+
+	return nil
+}
+
+// rotatedFiles lists the files a stream was rotated to, oldest first.
+// Only <name>-<rotatedTimeFormat>.ndjson[.gz] matches, so streams sharing a name prefix and other files are left alone.
+func (f *File) rotatedFiles(name string) ([]rotatedFile, error) {
+	entries, err := os.ReadDir(f.options.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("could not list rotated files: %v", err)
+	}
+
+	var rotated []rotatedFile
+	for _, entry := range entries {
+		if !entry.Type().IsRegular() {
+			continue
+		}
+
+		rotatedTime, ok := parseRotatedName(name, entry.Name())
+		if !ok {
+			continue
+		}
+
+		rotated = append(rotated, rotatedFile{
+			path:    filepath.Join(f.options.Dir, entry.Name()),
+			rotated: rotatedTime,
+		})
+	}
+
+	sort.Slice(rotated, func(i, j int) bool {
+		return rotated[i].rotated.Before(rotated[j].rotated)
+	})
+
+	return rotated, nil
+}
+
+// parseRotatedName returns the rotation time of a file name if it is a rotated file of the stream name.
+func parseRotatedName(name, fileName string) (time.Time, bool) {
+	timestamp, ok := strings.CutPrefix(fileName, name+"-")
+	if !ok {
+		return time.Time{}, false
+	}
+
+	timestamp = strings.TrimSuffix(timestamp, compressedSuffix)
+
+	timestamp, ok = strings.CutSuffix(timestamp, activeSuffix)
+	if !ok {
+		return time.Time{}, false
+	}
+
+	rotatedTime, err := time.Parse(rotatedTimeFormat, timestamp)
+	if err != nil || rotatedTime.Format(rotatedTimeFormat) != timestamp {
+		return time.Time{}, false
+	}
+
+	return rotatedTime, true
+}
+
+// Write appends the rows to the active file of the stream, rotating it when needed.
+// Rows are buffered until Flush.
+func (f *File) Write(_ context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	now := time.Now()
+
+	for i, row := range rows {
+		line, err := json.Marshal(row)
+		if err != nil {
+			return i, fmt.Errorf("could not encode row: %v", err)
+		}
+		line = append(line, '\n')
+
+		active, err := f.open(stream)
+		if err != nil {
+			return i, err
+		}
+
+		if f.needsRotation(active, len(line), now) {
+			if err := f.rotate(stream, now); err != nil {
+				return i, err
+			}
+
+			if active, err = f.open(stream); err != nil {
+				return i, err
+			}
+		}
+
+		if _, err := active.writer.Write(line); err != nil {
+			return i, fmt.Errorf("could not write '%s': %v", active.path, err)
+		}
+
+		active.size += int64(len(line))
+		active.modTime = now
+	}
+
+	f.logger.WithField("module", "file").WithField("sink", f.name).WithField("stream", stream).
+		WithField("total", len(rows)).Debug("wrote rows")
+
+	return len(rows), nil
+}
+
+// Flush writes the buffered rows and syncs the active files to disk.
+func (f *File) Flush(_ context.Context) error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	for _, active := range f.files {
+		if err := active.writer.Flush(); err != nil {
+			return fmt.Errorf("could not write '%s': %v", active.path, err)
+		}
+
+		if err := active.file.Sync(); err != nil {
+			return fmt.Errorf("could not sync '%s': %v", active.path, err)
+		}
+	}
+
+	return nil
+}
+
+func (f *File) Close() error {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	var errs []error
+	for stream := range f.files {
+		if err := f.close(stream); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Health checks the directory can be written to.
+func (f *File) Health(_ context.Context) error {
+	tmpFile, err := os.CreateTemp(f.options.Dir, ".health-*.tmp")
+	if err != nil {
+		return fmt.Errorf("file sink directory '%s' is not writable: %v", f.options.Dir, err)
+	}
+
+	_ = tmpFile.Close()
+
+	return os.Remove(tmpFile.Name())
+}
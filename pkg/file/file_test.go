@@ -0,0 +1,313 @@
+package file
+
+import (
+	"bufio"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/sink/sinktest"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func newTestFile(t *testing.T, options Options) *File {
+	t.Helper()
+
+	if options.Dir == "" {
+		options.Dir = t.TempDir()
+	}
+
+	file, err := New(sinktest.Logger(), "file", options)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	t.Cleanup(func() { _ = file.Close() })
+
+	return file
+}
+
+func testRows(from, n int) []map[string]string {
+	return sinktest.Rows(from, n, map[string]string{"Action": strings.Repeat("x", 50)})
+}
+
+func write(t *testing.T, file *File, rows []map[string]string) {
+	t.Helper()
+
+	written, err := file.Write(context.Background(), sink.StreamAudit, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	if err := file.Flush(context.Background()); err != nil {
+		t.Fatalf("could not flush: %v", err)
+	}
+}
+
+// readEventIDs returns the event ids in a file, decompressing it when gzipped.
+func readEventIDs(t *testing.T, path string) []string {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatalf("could not open '%s': %v", path, err)
+	}
+
+	defer f.Close()
+
+	reader := io.Reader(f)
+	if strings.HasSuffix(path, compressedSuffix) {
+		gzipReader, err := gzip.NewReader(f)
+		if err != nil {
+			t.Fatalf("'%s' is not gzipped: %v", path, err)
+		}
+
+		reader = gzipReader
+	}
+
+	var ids []string
+
+	scanner := bufio.NewScanner(reader)
+	for scanner.Scan() {
+		var row map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("could not decode line of '%s': %v", path, err)
+		}
+
+		ids = append(ids, row["EventId"])
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("could not read '%s': %v", path, err)
+	}
+
+	return ids
+}
+
+func rotatedPaths(t *testing.T, file *File) []string {
+	t.Helper()
+
+	rotated, err := file.rotatedFiles(defaultNames[sink.StreamAudit])
+	if err != nil {
+		t.Fatalf("could not list rotated files: %v", err)
+	}
+
+	paths := make([]string, len(rotated))
+	for i, r := range rotated {
+		paths[i] = r.path
+	}
+
+	return paths
+}
+
+func activePath(file *File) string {
+	return filepath.Join(file.options.Dir, defaultNames[sink.StreamAudit]+activeSuffix)
+}
+
+func TestSizeRotation(t *testing.T) {
+	// every line is about 100 bytes, so a file holds a few rows
+	file := newTestFile(t, Options{MaxBytes: 350})
+
+	write(t, file, testRows(0, 10))
+
+	var ids []string
+	for _, path := range rotatedPaths(t, file) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("could not stat '%s': %v", path, err)
+		}
+
+		if info.Size() > 350 {
+			t.Errorf("'%s' is %d bytes, over the max of 350", path, info.Size())
+		}
+
+		ids = append(ids, readEventIDs(t, path)...)
+	}
+
+	if len(ids) == 0 {
+		t.Fatal("expected the active file to be rotated")
+	}
+
+	ids = append(ids, readEventIDs(t, activePath(file))...)
+
+	if len(ids) != 10 {
+		t.Fatalf("files hold %d rows, expected 10", len(ids))
+	}
+
+	for i, id := range ids {
+		if expected := fmt.Sprintf("event-%d", i); id != expected {
+			t.Fatalf("row %d is '%s', expected '%s'", i, id, expected)
+		}
+	}
+}
+
+func TestTimeRotation(t *testing.T) {
+	file := newTestFile(t, Options{RotateInterval: time.Hour})
+
+	write(t, file, testRows(0, 3))
+
+	if paths := rotatedPaths(t, file); len(paths) != 0 {
+		t.Fatalf("expected no rotation within the interval, got %v", paths)
+	}
+
+	// the active file now holds rows of an earlier interval
+	file.files[sink.StreamAudit].modTime = time.Now().Add(-2 * time.Hour)
+
+	write(t, file, testRows(3, 2))
+
+	paths := rotatedPaths(t, file)
+	if len(paths) != 1 {
+		t.Fatalf("expected a single rotated file, got %v", paths)
+	}
+
+	if ids := readEventIDs(t, paths[0]); len(ids) != 3 {
+		t.Fatalf("rotated file holds %d rows, expected 3", len(ids))
+	}
+
+	if ids := readEventIDs(t, activePath(file)); len(ids) != 2 || ids[0] != "event-3" {
+		t.Fatalf("active file holds %v, expected the rows of the new interval", ids)
+	}
+}
+
+func TestCompressOnRotate(t *testing.T) {
+	file := newTestFile(t, Options{MaxBytes: 350, Compress: true})
+
+	write(t, file, testRows(0, 10))
+
+	paths := rotatedPaths(t, file)
+	if len(paths) == 0 {
+		t.Fatal("expected the active file to be rotated")
+	}
+
+	rows := 0
+	for _, path := range paths {
+		if !strings.HasSuffix(path, activeSuffix+compressedSuffix) {
+			t.Fatalf("rotated file '%s' is not compressed", path)
+		}
+
+		if _, err := os.Stat(strings.TrimSuffix(path, compressedSuffix)); !os.IsNotExist(err) {
+			t.Errorf("uncompressed file of '%s' was not removed", path)
+		}
+
+		rows += len(readEventIDs(t, path))
+	}
+
+	rows += len(readEventIDs(t, activePath(file)))
+
+	if rows != 10 {
+		t.Fatalf("files hold %d rows, expected 10", rows)
+	}
+
+	tmpFiles, _ := filepath.Glob(filepath.Join(file.options.Dir, "*.tmp"))
+	if len(tmpFiles) != 0 {
+		t.Fatalf("temporary files were left behind: %v", tmpFiles)
+	}
+}
+
+func TestRetention(t *testing.T) {
+	dir := t.TempDir()
+
+	// files of another stream sharing the name prefix, and files that are not rotated files, are kept
+	unrelated := []string{
+		defaultNames[sink.StreamAudit] + "-old-20000101T000000.000000000Z" + activeSuffix,
+		defaultNames[sink.StreamAudit] + "-notes" + activeSuffix,
+		defaultNames[sink.StreamAudit] + "-20000101T000000.000000000Z" + activeSuffix + ".bak",
+	}
+
+	for _, name := range unrelated {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte("{}\n"), 0o600); err != nil {
+			t.Fatalf("could not create '%s': %v", name, err)
+		}
+	}
+
+	file := newTestFile(t, Options{Dir: dir, MaxBytes: 150, MaxFiles: 2, Compress: true})
+
+	write(t, file, testRows(0, 6))
+
+	paths := rotatedPaths(t, file)
+	if len(paths) != 2 {
+		t.Fatalf("expected 2 rotated files to be kept, got %v", paths)
+	}
+
+	// the newest rotated files are kept, holding the rows before the active file
+	if ids := readEventIDs(t, paths[1]); len(ids) != 1 || ids[0] != "event-4" {
+		t.Fatalf("newest rotated file holds %v, expected event-4", ids)
+	}
+
+	if ids := readEventIDs(t, paths[0]); len(ids) != 1 || ids[0] != "event-3" {
+		t.Fatalf("oldest kept rotated file holds %v, expected event-3", ids)
+	}
+
+	for _, name := range unrelated {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("unrelated file '%s' was removed: %v", name, err)
+		}
+	}
+}
+
+func TestParseRotatedName(t *testing.T) {
+	rotated := time.Date(2024, 5, 1, 12, 30, 0, 5, time.UTC)
+	timestamp := rotated.Format(rotatedTimeFormat)
+
+	tests := []struct {
+		fileName string
+		ok       bool
+	}{
+		{fileName: "audit-" + timestamp + ".ndjson", ok: true},
+		{fileName: "audit-" + timestamp + ".ndjson.gz", ok: true},
+		{fileName: "audit.ndjson"},
+		{fileName: "audit-" + timestamp + ".ndjson.gz.tmp"},
+		{fileName: "audit-" + timestamp + ".ndjson123.tmp"},
+		{fileName: "audit-network-" + timestamp + ".ndjson"},
+		{fileName: "audit-2024.ndjson"},
+	}
+
+	for _, test := range tests {
+		parsed, ok := parseRotatedName("audit", test.fileName)
+		if ok != test.ok {
+			t.Errorf("'%s': got %v, expected %v", test.fileName, ok, test.ok)
+		}
+
+		if ok && !parsed.Equal(rotated) {
+			t.Errorf("'%s': parsed %s, expected %s", test.fileName, parsed, rotated)
+		}
+	}
+}
+
+func TestModes(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "logs")
+
+	file := newTestFile(t, Options{Dir: dir, MaxBytes: 150, Compress: true})
+
+	write(t, file, testRows(0, 3))
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		t.Fatalf("could not stat directory: %v", err)
+	}
+
+	if info.Mode().Perm() != defaultDirMode {
+		t.Errorf("directory has mode %o, expected %o", info.Mode().Perm(), defaultDirMode)
+	}
+
+	for _, path := range append(rotatedPaths(t, file), activePath(file)) {
+		info, err := os.Stat(path)
+		if err != nil {
+			t.Fatalf("could not stat '%s': %v", path, err)
+		}
+
+		if info.Mode().Perm() != defaultFileMode {
+			t.Errorf("'%s' has mode %o, expected %o", path, info.Mode().Perm(), defaultFileMode)
+		}
+	}
+}
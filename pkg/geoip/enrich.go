@@ -0,0 +1,63 @@
+package geoip
+
+import (
+	"fmt"
+	"net/netip"
+)
+
+var (
+	// enrichedFields are the endpoint columns that are looked up, also used as prefix of the added columns
+	enrichedFields = []string{"Src", "Dst"}
+)
+
+func parseEndpoint(endpoint string) (netip.Addr, error) {
+	if addrPort, err := netip.ParseAddrPort(endpoint); err == nil {
+		return addrPort.Addr(), nil
+	}
+
+	return netip.ParseAddr(endpoint)
+}
+
+// EnrichNetworkLogs adds country, city and ASN columns for the public endpoints of converted network logs.
+// Database files are reloaded first if they were replaced on disk.
+func (g *GeoIP) EnrichNetworkLogs(logs []map[string]string) error {
+	logger := g.logger.WithField("module", "geoip")
+
+	if err := g.Reload(); err != nil {
+		return fmt.Errorf("could not reload geoip databases: %v", err)
+	}
+
+	enriched := 0
+
+	for _, log := range logs {
+		for _, field := range enrichedFields {
+			addr, err := parseEndpoint(log[field])
+			if err != nil {
+				logger.WithError(err).WithField("endpoint", log[field]).Trace("skipping unparseable endpoint")
+				continue
+			}
+
+			location, found, err := g.Lookup(addr)
+			if err != nil {
+				return err
+			}
+
+			if !found {
+				continue
+			}
+
+			log[field+"Country"] = location.Country
+			log[field+"City"] = location.City
+			if location.ASN != 0 {
+				log[field+"ASN"] = fmt.Sprintf("%d", location.ASN)
+				log[field+"ASOrg"] = location.ASOrg
+			}
+
+			enriched++
+		}
+	}
+
+	logger.WithField("total", len(logs)).WithField("enriched", enriched).Debug("enriched network logs")
+
+	return nil
+}
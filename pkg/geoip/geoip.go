@@ -0,0 +1,188 @@
+package geoip
+
+import (
+	"fmt"
+	"github.com/oschwald/maxminddb-golang"
+	"github.com/sirupsen/logrus"
+	"net"
+	"net/netip"
+	"os"
+	"sync"
+	"time"
+)
+
+var (
+	// tailnetPrefixes are the CGNAT and ULA ranges tailscale assigns to nodes
+	tailnetPrefixes = []netip.Prefix{
+		netip.MustParsePrefix("100.64.0.0/10"),
+		netip.MustParsePrefix("fd7a:115c:a1e0::/48"),
+	}
+)
+
+// record is the subset of the GeoLite2/GeoIP2 City, Country and ASN layouts we care about.
+// Multiple databases can be combined since every lookup only fills the fields it knows.
+type record struct {
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	ASN          uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+type Location struct {
+	Country string
+	City    string
+	ASN     uint
+	ASOrg   string
+}
+
+type database struct {
+	path    string
+	modTime time.Time
+	reader  *maxminddb.Reader
+}
+
+type GeoIP struct {
+	logger *logrus.Logger
+
+	lock      sync.RWMutex
+	databases []*database
+}
+
+func New(logger *logrus.Logger, paths []string) (*GeoIP, error) {
+	if logger == nil {
+		return nil, fmt.Errorf("nil logger provided")
+	}
+	if len(paths) == 0 {
+		return nil, fmt.Errorf("no geoip database provided")
+	}
+
+	geo := GeoIP{
+		logger: logger,
+	}
+
+	for _, path := range paths {
+		geo.databases = append(geo.databases, &database{path: path})
+	}
+
+	if err := geo.Reload(); err != nil {
+		return nil, err
+	}
+
+	return &geo, nil
+}
+
+// Reload reopens every database file that changed on disk since it was last loaded.
+func (g *GeoIP) Reload() error {
+	logger := g.logger.WithField("module", "geoip")
+
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, db := range g.databases {
+		stat, err := os.Stat(db.path)
+		if err != nil {
+			return fmt.Errorf("could not stat geoip database '%s': %v", db.path, err)
+		}
+
+		if db.reader != nil && stat.ModTime().Equal(db.modTime) {
+			continue
+		}
+
+		reader, err := maxminddb.Open(db.path)
+		if err != nil {
+			return fmt.Errorf("could not open geoip database '%s': %v", db.path, err)
+		}
+
+		if db.reader != nil {
+			if err := db.reader.Close(); err != nil {
+				logger.WithError(err).WithField("path", db.path).Warn("could not close previous geoip database")
+			}
+		}
+
+		db.reader = reader
+		db.modTime = stat.ModTime()
+
+		logger.WithField("path", db.path).WithField("type", reader.Metadata.DatabaseType).
+			Info("loaded geoip database")
+	}
+
+	return nil
+}
+
+func (g *GeoIP) Close() error {
+	g.lock.Lock()
+	defer g.lock.Unlock()
+
+	for _, db := range g.databases {
+		if db.reader == nil {
+			continue
+		}
+
+		if err := db.reader.Close(); err != nil {
+			return fmt.Errorf("could not close geoip database '%s': %v", db.path, err)
+		}
+
+		db.reader = nil
+	}
+
+	return nil
+}
+
+// IsPublic returns whether an address is routed outside the tailnet and worth looking up.
+func IsPublic(addr netip.Addr) bool {
+	if !addr.IsValid() || addr.IsPrivate() || addr.IsLoopback() || addr.IsLinkLocalUnicast() ||
+		addr.IsMulticast() || addr.IsUnspecified() {
+		return false
+	}
+
+	for _, prefix := range tailnetPrefixes {
+		if prefix.Contains(addr) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Lookup resolves the location of a public address, returning false if nothing is known.
+func (g *GeoIP) Lookup(addr netip.Addr) (Location, bool, error) {
+	var location Location
+
+	if !IsPublic(addr) {
+		return location, false, nil
+	}
+
+	g.lock.RLock()
+	defer g.lock.RUnlock()
+
+	found := false
+
+	for _, db := range g.databases {
+		var rec record
+		if err := db.reader.Lookup(net.IP(addr.AsSlice()), &rec); err != nil {
+			return location, false, fmt.Errorf("could not lookup '%s' in '%s': %v", addr, db.path, err)
+		}
+
+		if location.Country == "" && rec.Country.ISOCode != "" {
+			location.Country = rec.Country.ISOCode
+			found = true
+		}
+
+		if location.City == "" && rec.City.Names["en"] != "" {
+			location.City = rec.City.Names["en"]
+			found = true
+		}
+
+		if location.ASN == 0 && rec.ASN != 0 {
+			location.ASN = rec.ASN
+			location.ASOrg = rec.Organization
+			found = true
+		}
+	}
+
+	return location, found, nil
+}
@@ -0,0 +1,221 @@
+package geoip
+
+import (
+	"bytes"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// mmdbString encodes a short UTF-8 string in the MaxMind DB data format.
+func mmdbString(buf *bytes.Buffer, value string) {
+	buf.WriteByte(2<<5 | byte(len(value)))
+	buf.WriteString(value)
+}
+
+// mmdbUint encodes a value of up to a byte as a uint16 (5) or uint32 (6).
+func mmdbUint(buf *bytes.Buffer, typ byte, value byte) {
+	buf.WriteByte(typ<<5 | 1)
+	buf.WriteByte(value)
+}
+
+// writeDatabase writes an IPv4 country database that maps every address to country.
+// The file is replaced instead of rewritten, since the reader may still have the previous one mapped.
+func writeDatabase(t *testing.T, path, country string) {
+	t.Helper()
+
+	var buf bytes.Buffer
+
+	// a single node with both 24 bit records pointing at the first record of the data section,
+	// which is at node count + the 16 byte separator
+	buf.Write([]byte{0, 0, 17, 0, 0, 17})
+	buf.Write(make([]byte, 16))
+
+	// {"country": {"iso_code": country}}
+	buf.WriteByte(7<<5 | 1)
+	mmdbString(&buf, "country")
+	buf.WriteByte(7<<5 | 1)
+	mmdbString(&buf, "iso_code")
+	mmdbString(&buf, country)
+
+	buf.WriteString("\xAB\xCD\xEFMaxMind.com")
+
+	buf.WriteByte(7<<5 | 5)
+	mmdbString(&buf, "node_count")
+	mmdbUint(&buf, 6, 1)
+	mmdbString(&buf, "record_size")
+	mmdbUint(&buf, 5, 24)
+	mmdbString(&buf, "ip_version")
+	mmdbUint(&buf, 5, 4)
+	mmdbString(&buf, "binary_format_major_version")
+	mmdbUint(&buf, 5, 2)
+	mmdbString(&buf, "database_type")
+	mmdbString(&buf, "Test-Country")
+
+	tmpPath := path + ".tmp"
+	if err := os.WriteFile(tmpPath, buf.Bytes(), 0o600); err != nil {
+		t.Fatalf("could not write database: %v", err)
+	}
+
+	if err := os.Rename(tmpPath, path); err != nil {
+		t.Fatalf("could not replace database: %v", err)
+	}
+}
+
+func newTestGeoIP(t *testing.T, path string) *GeoIP {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	geo, err := New(logger, []string{path})
+	if err != nil {
+		t.Fatalf("could not open database: %v", err)
+	}
+
+	t.Cleanup(func() { _ = geo.Close() })
+
+	return geo
+}
+
+func lookupCountry(t *testing.T, geo *GeoIP) string {
+	t.Helper()
+
+	location, found, err := geo.Lookup(netip.MustParseAddr("8.8.8.8"))
+	if err != nil {
+		t.Fatalf("could not lookup address: %v", err)
+	}
+
+	if !found {
+		t.Fatal("address was not found")
+	}
+
+	return location.Country
+}
+
+func TestIsPublic(t *testing.T) {
+	tests := []struct {
+		addr   string
+		public bool
+	}{
+		{addr: "8.8.8.8", public: true},
+		{addr: "2001:4860:4860::8888", public: true},
+		// tailnet CGNAT and ULA ranges
+		{addr: "100.64.0.1"},
+		{addr: "100.127.255.254"},
+		{addr: "fd7a:115c:a1e0::1"},
+		// the edges of the CGNAT range are public
+		{addr: "100.63.255.255", public: true},
+		{addr: "100.128.0.0", public: true},
+		// private, loopback and other local ranges
+		{addr: "10.0.0.1"},
+		{addr: "172.16.0.1"},
+		{addr: "192.168.1.1"},
+		{addr: "fd00::1"},
+		{addr: "127.0.0.1"},
+		{addr: "::1"},
+		{addr: "169.254.1.1"},
+		{addr: "fe80::1"},
+		{addr: "224.0.0.1"},
+		{addr: "0.0.0.0"},
+	}
+
+	for _, test := range tests {
+		if public := IsPublic(netip.MustParseAddr(test.addr)); public != test.public {
+			t.Errorf("IsPublic(%s) = %v, expected %v", test.addr, public, test.public)
+		}
+	}
+
+	if IsPublic(netip.Addr{}) {
+		t.Error("an invalid address is public")
+	}
+}
+
+func TestLookupSkipsTailnetAddresses(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeDatabase(t, path, "BE")
+
+	geo := newTestGeoIP(t, path)
+
+	// the database maps every address, so anything found would have been looked up
+	for _, addr := range []string{"100.64.0.1", "192.168.1.1"} {
+		if _, found, err := geo.Lookup(netip.MustParseAddr(addr)); found || err != nil {
+			t.Errorf("%s was looked up: found %v, error %v", addr, found, err)
+		}
+	}
+}
+
+func TestReload(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeDatabase(t, path, "BE")
+
+	loaded := time.Now().Add(-time.Hour)
+	if err := os.Chtimes(path, loaded, loaded); err != nil {
+		t.Fatalf("could not set modification time: %v", err)
+	}
+
+	geo := newTestGeoIP(t, path)
+
+	if country := lookupCountry(t, geo); country != "BE" {
+		t.Fatalf("got country '%s', expected BE", country)
+	}
+
+	// a new database with the same modification time is not picked up
+	writeDatabase(t, path, "NL")
+	if err := os.Chtimes(path, loaded, loaded); err != nil {
+		t.Fatalf("could not set modification time: %v", err)
+	}
+
+	if err := geo.Reload(); err != nil {
+		t.Fatalf("could not reload: %v", err)
+	}
+
+	if country := lookupCountry(t, geo); country != "BE" {
+		t.Fatalf("got country '%s' without a changed modification time, expected BE", country)
+	}
+
+	changed := loaded.Add(time.Minute)
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatalf("could not set modification time: %v", err)
+	}
+
+	if err := geo.Reload(); err != nil {
+		t.Fatalf("could not reload: %v", err)
+	}
+
+	if country := lookupCountry(t, geo); country != "NL" {
+		t.Fatalf("got country '%s' after the database changed, expected NL", country)
+	}
+}
+
+func TestReloadKeepsDatabaseOnError(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "country.mmdb")
+	writeDatabase(t, path, "BE")
+
+	geo := newTestGeoIP(t, path)
+
+	if err := os.WriteFile(path+".tmp", []byte("not a database"), 0o600); err != nil {
+		t.Fatalf("could not write file: %v", err)
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		t.Fatalf("could not replace database: %v", err)
+	}
+
+	changed := time.Now().Add(time.Minute)
+	if err := os.Chtimes(path, changed, changed); err != nil {
+		t.Fatalf("could not set modification time: %v", err)
+	}
+
+	if err := geo.Reload(); err == nil {
+		t.Fatal("expected an invalid database to fail the reload")
+	}
+
+	if country := lookupCountry(t, geo); country != "BE" {
+		t.Fatalf("got country '%s', expected the previous database to be kept", country)
+	}
+}
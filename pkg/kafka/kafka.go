@@ -0,0 +1,309 @@
+package kafka
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"github.com/twmb/franz-go/pkg/kgo"
+	"github.com/twmb/franz-go/pkg/sasl/plain"
+	"github.com/twmb/franz-go/pkg/sasl/scram"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	MechanismPlain       = "plain"
+	MechanismScramSHA256 = "scram-sha-256"
+	MechanismScramSHA512 = "scram-sha-512"
+
+	defaultClientID = "tail2sen"
+	defaultKey      = "EventId"
+)
+
+var (
+	defaultTopics = map[sink.Stream]string{
+		sink.StreamAudit:   "tailscale-audit",
+		sink.StreamNetwork: "tailscale-network",
+	}
+
+	compressionCodecs = map[string]kgo.CompressionCodec{
+		"none":   kgo.NoCompression(),
+		"gzip":   kgo.GzipCompression(),
+		"snappy": kgo.SnappyCompression(),
+		"lz4":    kgo.Lz4Compression(),
+		"zstd":   kgo.ZstdCompression(),
+	}
+)
+
+// StreamOptions decides the topic and message key of a stream.
+type StreamOptions struct {
+	Topic string
+	// Key is the column used as message key, a field of a JSON column is selected as Column.field, e.g. Actor.id.
+	// Messages with the same key land on the same partition, defaults to EventId.
+	// Idempotent writes only prevent duplicates within a producer session, a rerun produces rows again,
+	// so consumers deduplicate on the EventId key or the EventId field of the message.
+	Key string
+}
+
+type Options struct {
+	Brokers  []string
+	ClientID string
+
+	// UseTLS connects to the brokers over TLS with the TLS options.
+	UseTLS bool
+	TLS    utils.TLSOptions
+
+	// Mechanism is the SASL mechanism, SASL is not used when empty.
+	Mechanism string
+	Username  string
+	Password  string
+
+	// Compression is none, gzip, snappy, lz4 or zstd, defaults to the client default.
+	Compression string
+	// BatchMaxBytes bounds the size of a record batch per partition.
+	BatchMaxBytes int32
+	// Linger waits for more records before sending a batch that is not full.
+	Linger time.Duration
+	// DisableIdempotence is needed for brokers or ACLs without idempotent write support.
+	DisableIdempotence bool
+
+	Streams map[sink.Stream]StreamOptions
+}
+
+// Kafka is a sink that produces every row as a JSON message to the topic of its stream.
+type Kafka struct {
+	name    string
+	logger  *logrus.Logger
+	options Options
+	client  *kgo.Client
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*Kafka, error) {
+	if len(options.Brokers) == 0 {
+		return nil, fmt.Errorf("no kafka brokers provided")
+	}
+
+	if options.ClientID == "" {
+		options.ClientID = defaultClientID
+	}
+
+	streams := make(map[sink.Stream]StreamOptions, len(defaultTopics))
+	for stream, topic := range defaultTopics {
+		streamOptions := options.Streams[stream]
+		if streamOptions.Topic == "" {
+			streamOptions.Topic = topic
+		}
+		if streamOptions.Key == "" {
+			streamOptions.Key = defaultKey
+		}
+
+		streams[stream] = streamOptions
+	}
+	options.Streams = streams
+
+	opts := []kgo.Opt{
+		kgo.SeedBrokers(options.Brokers...),
+		kgo.ClientID(options.ClientID),
+		kgo.WithLogger(&kgoLogger{logger: logger.WithField("module", "kafka").WithField("sink", name)}),
+		// idempotent writes require acknowledgement of every in-sync replica
+		kgo.RequiredAcks(kgo.AllISRAcks()),
+	}
+
+	if options.DisableIdempotence {
+		opts = append(opts, kgo.DisableIdempotentWrite())
+	}
+
+	if options.UseTLS {
+		tlsConfig, err := options.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		opts = append(opts, kgo.DialTLSConfig(tlsConfig))
+	}
+
+	switch options.Mechanism {
+	case "":
+	case MechanismPlain:
+		opts = append(opts, kgo.SASL(plain.Auth{User: options.Username, Pass: options.Password}.AsMechanism()))
+	case MechanismScramSHA256:
+		opts = append(opts, kgo.SASL(scram.Auth{User: options.Username, Pass: options.Password}.AsSha256Mechanism()))
+	case MechanismScramSHA512:
+		opts = append(opts, kgo.SASL(scram.Auth{User: options.Username, Pass: options.Password}.AsSha512Mechanism()))
+	default:
+		return nil, fmt.Errorf("unknown kafka sasl mechanism '%s'", options.Mechanism)
+	}
+
+	if options.Compression != "" {
+		codec, ok := compressionCodecs[options.Compression]
+		if !ok {
+			return nil, fmt.Errorf("unknown kafka compression '%s'", options.Compression)
+		}
+
+		opts = append(opts, kgo.ProducerBatchCompression(codec))
+	}
+
+	if options.BatchMaxBytes > 0 {
+		opts = append(opts, kgo.ProducerBatchMaxBytes(options.BatchMaxBytes))
+	}
+
+	if options.Linger > 0 {
+		opts = append(opts, kgo.ProducerLinger(options.Linger))
+	}
+
+	client, err := kgo.NewClient(opts...)
+	if err != nil {
+		return nil, fmt.Errorf("could not create kafka client: %v", err)
+	}
+
+	return &Kafka{
+		name:    name,
+		logger:  logger,
+		options: options,
+		client:  client,
+	}, nil
+}
+
+func (k *Kafka) Name() string {
+	return k.name
+}
+
+// messageKey returns the key column of a row, or a field of it when the column holds a JSON object.
+func messageKey(row map[string]string, key string) []byte {
+	if value, ok := row[key]; ok {
+		if value == "" {
+			return nil
+		}
+		return []byte(value)
+	}
+
+	column, field, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil
+	}
+
+	var object map[string]interface{}
+	if err := json.Unmarshal([]byte(row[column]), &object); err != nil {
+		return nil
+	}
+
+	value, ok := object[field]
+	if !ok || value == nil {
+		return nil
+	}
+
+	return []byte(fmt.Sprintf("%v", value))
+}
+
+// Write produces the rows and waits until the brokers acknowledged them, returning how many leading rows were produced.
+func (k *Kafka) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	logger := k.logger.WithField("module", "kafka").WithField("sink", k.name).WithField("stream", stream)
+
+	options := k.options.Streams[stream]
+
+	logger.WithField("total", len(rows)).WithField("topic", options.Topic).Info("producing messages")
+
+	errs := make([]error, len(rows))
+	var wg sync.WaitGroup
+
+	for i, row := range rows {
+		value, err := json.Marshal(row)
+		if err != nil {
+			errs[i] = fmt.Errorf("could not encode row: %v", err)
+
+			// the rows after it are never produced, so the next run retries them after this one
+			if skipped := len(rows) - i - 1; skipped > 0 {
+				logger.WithField("row", i).WithField("skipped", skipped).
+					Warn("not producing the rows after a row that could not be encoded")
+			}
+
+			break
+		}
+
+		record := &kgo.Record{
+			Topic: options.Topic,
+			Key:   messageKey(row, options.Key),
+			Value: value,
+		}
+
+		if rowTime, err := time.Parse(time.RFC3339Nano, row["TimeGenerated"]); err == nil {
+			record.Timestamp = rowTime
+		}
+
+		wg.Add(1)
+		k.client.Produce(ctx, record, func(_ *kgo.Record, err error) {
+			errs[i] = err
+			wg.Done()
+		})
+	}
+
+	wg.Wait()
+
+	for i, err := range errs {
+		if err != nil {
+			return i, fmt.Errorf("could not produce message to '%s': %v", options.Topic, err)
+		}
+	}
+
+	logger.WithField("total", len(rows)).Info("produced messages")
+
+	return len(rows), nil
+}
+
+// Flush waits for messages still buffered by the client, Write already waits for the messages it produced.
+func (k *Kafka) Flush(ctx context.Context) error {
+	return k.client.Flush(ctx)
+}
+
+func (k *Kafka) Close() error {
+	k.client.Close()
+	return nil
+}
+
+// Health checks a broker can be reached with the configured credentials.
+func (k *Kafka) Health(ctx context.Context) error {
+	if err := k.client.Ping(ctx); err != nil {
+		return fmt.Errorf("kafka brokers are unhealthy: %v", err)
+	}
+
+	return nil
+}
+
+// kgoLogger forwards the logs of the kafka client to logrus.
+type kgoLogger struct {
+	logger *logrus.Entry
+}
+
+func (l *kgoLogger) Level() kgo.LogLevel {
+	switch {
+	case l.logger.Logger.IsLevelEnabled(logrus.DebugLevel):
+		return kgo.LogLevelDebug
+	case l.logger.Logger.IsLevelEnabled(logrus.InfoLevel):
+		// the info logs of the client are about connections and metadata, too noisy for a run
+		return kgo.LogLevelWarn
+	default:
+		return kgo.LogLevelError
+	}
+}
+
+func (l *kgoLogger) Log(level kgo.LogLevel, msg string, keyvals ...interface{}) {
+	entry := l.logger
+	for i := 0; i+1 < len(keyvals); i += 2 {
+		entry = entry.WithField(fmt.Sprintf("%v", keyvals[i]), keyvals[i+1])
+	}
+
+	switch level {
+	case kgo.LogLevelError:
+		entry.Error(msg)
+	case kgo.LogLevelWarn:
+		entry.Warn(msg)
+	case kgo.LogLevelInfo:
+		entry.Info(msg)
+	default:
+		entry.Debug(msg)
+	}
+}
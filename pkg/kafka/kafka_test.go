@@ -0,0 +1,131 @@
+package kafka
+
+import (
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/sink/sinktest"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestMessageKey(t *testing.T) {
+	row := map[string]string{
+		"EventId": "event-0",
+		"NodeID":  "",
+		"Actor":   `{"id": "user", "loginName": "user@example.com", "tags": null, "count": 2}`,
+		"Target":  "not json",
+	}
+
+	tests := []struct {
+		key      string
+		expected string
+	}{
+		{key: "EventId", expected: "event-0"},
+		{key: "Actor.id", expected: "user"},
+		{key: "Actor.count", expected: "2"},
+		// missing and empty values are not keyed, so the client spreads them over the partitions
+		{key: "NodeID"},
+		{key: "Missing"},
+		{key: "Actor.missing"},
+		{key: "Actor.tags"},
+		{key: "Missing.id"},
+		{key: "Target.id"},
+	}
+
+	for _, test := range tests {
+		key := messageKey(row, test.key)
+		if string(key) != test.expected {
+			t.Errorf("messageKey(%s) = '%s', expected '%s'", test.key, key, test.expected)
+		}
+
+		if test.expected == "" && key != nil {
+			t.Errorf("messageKey(%s) returned an empty key instead of none", test.key)
+		}
+	}
+}
+
+func TestNew(t *testing.T) {
+	brokers := []string{"127.0.0.1:9092"}
+
+	tests := []struct {
+		name    string
+		options Options
+		err     string
+	}{
+		{name: "defaults", options: Options{Brokers: brokers}},
+		{name: "no brokers", options: Options{}, err: "no kafka brokers provided"},
+		{name: "plain", options: Options{Brokers: brokers, Mechanism: MechanismPlain, Username: "user", Password: "password"}},
+		{name: "scram sha 256", options: Options{Brokers: brokers, Mechanism: MechanismScramSHA256, Username: "user", Password: "password"}},
+		{name: "scram sha 512", options: Options{Brokers: brokers, Mechanism: MechanismScramSHA512, Username: "user", Password: "password"}},
+		{name: "unknown mechanism", options: Options{Brokers: brokers, Mechanism: "gssapi"}, err: "unknown kafka sasl mechanism 'gssapi'"},
+		{name: "unknown compression", options: Options{Brokers: brokers, Compression: "brotli"}, err: "unknown kafka compression 'brotli'"},
+		{
+			name:    "missing ca file",
+			options: Options{Brokers: brokers, UseTLS: true, TLS: utils.TLSOptions{CAFile: filepath.Join(t.TempDir(), "ca.pem")}},
+			err:     "could not read ca file",
+		},
+		{name: "tls options are unused without tls", options: Options{Brokers: brokers, TLS: utils.TLSOptions{CAFile: "missing.pem"}}},
+	}
+
+	for codec := range compressionCodecs {
+		tests = append(tests, struct {
+			name    string
+			options Options
+			err     string
+		}{name: "compression " + codec, options: Options{Brokers: brokers, Compression: codec}})
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			kafka, err := New(sinktest.Logger(), "kafka", test.options)
+
+			if test.err == "" {
+				if err != nil {
+					t.Fatalf("could not create sink: %v", err)
+				}
+
+				_ = kafka.Close()
+				return
+			}
+
+			if err == nil {
+				_ = kafka.Close()
+				t.Fatalf("expected error '%s'", test.err)
+			}
+
+			if !strings.Contains(err.Error(), test.err) {
+				t.Fatalf("got error '%v', expected '%s'", err, test.err)
+			}
+		})
+	}
+}
+
+func TestNewStreamDefaults(t *testing.T) {
+	kafka, err := New(sinktest.Logger(), "kafka", Options{
+		Brokers: []string{"127.0.0.1:9092"},
+		Streams: map[sink.Stream]StreamOptions{
+			sink.StreamAudit: {Key: "Actor.id"},
+		},
+	})
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	defer kafka.Close()
+
+	if kafka.options.ClientID != defaultClientID {
+		t.Errorf("got client id '%s', expected '%s'", kafka.options.ClientID, defaultClientID)
+	}
+
+	expected := map[sink.Stream]StreamOptions{
+		sink.StreamAudit:   {Topic: defaultTopics[sink.StreamAudit], Key: "Actor.id"},
+		sink.StreamNetwork: {Topic: defaultTopics[sink.StreamNetwork], Key: defaultKey},
+	}
+
+	for stream, options := range expected {
+		if kafka.options.Streams[stream] != options {
+			t.Errorf("stream %s has options %+v, expected %+v", stream, kafka.options.Streams[stream], options)
+		}
+	}
+}
@@ -0,0 +1,295 @@
+package s3
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	defaultRegion         = "us-east-1"
+	defaultMaxObjectBytes = 64 * 1000 * 1000 // 64MB uncompressed
+
+	objectSuffix      = ".ndjson.gz"
+	objectContentType = "application/gzip"
+)
+
+type Options struct {
+	// Endpoint is the url of the S3 compatible service, e.g. https://s3.eu-west-1.amazonaws.com or http://minio:9000.
+	Endpoint string
+	Region   string
+	Bucket   string
+	// PathStyle addresses the bucket in the path instead of the host name, which MinIO and most other services need.
+	PathStyle bool
+
+	// AccessKeyID, SecretAccessKey and SessionToken default to the AWS_* environment variables.
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+	TLS             utils.TLSOptions
+
+	// Prefix is prepended to every object key.
+	Prefix string
+	// Tailnet is the value of the tailnet partition.
+	Tailnet string
+	// MaxObjectBytes bounds the uncompressed size of a single object, defaults to 64MB.
+	MaxObjectBytes int
+}
+
+// S3 is a sink that archives rows as gzipped NDJSON objects in an S3 compatible bucket.
+// Objects are partitioned Hive style by tailnet, stream, day and hour of the rows.
+type S3 struct {
+	name       string
+	logger     *logrus.Logger
+	options    Options
+	endpoint   *url.URL
+	httpClient *http.Client
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*S3, error) {
+	if options.Endpoint == "" {
+		return nil, fmt.Errorf("no s3 endpoint provided")
+	}
+	if options.Bucket == "" {
+		return nil, fmt.Errorf("no s3 bucket provided")
+	}
+
+	endpoint, err := url.Parse(strings.TrimSuffix(options.Endpoint, "/"))
+	if err != nil || endpoint.Host == "" {
+		return nil, fmt.Errorf("invalid s3 endpoint '%s'", options.Endpoint)
+	}
+
+	if options.Region == "" {
+		options.Region = defaultRegion
+	}
+
+	if options.AccessKeyID == "" && options.SecretAccessKey == "" {
+		options.AccessKeyID = os.Getenv("AWS_ACCESS_KEY_ID")
+		options.SecretAccessKey = os.Getenv("AWS_SECRET_ACCESS_KEY")
+		options.SessionToken = os.Getenv("AWS_SESSION_TOKEN")
+	}
+
+	if options.AccessKeyID == "" || options.SecretAccessKey == "" {
+		return nil, fmt.Errorf("no s3 credentials provided")
+	}
+
+	if options.Prefix != "" && !strings.HasSuffix(options.Prefix, "/") {
+		options.Prefix += "/"
+	}
+
+	if options.MaxObjectBytes <= 0 {
+		options.MaxObjectBytes = defaultMaxObjectBytes
+	}
+
+	tlsConfig, err := options.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	return &S3{
+		name:       name,
+		logger:     logger,
+		options:    options,
+		endpoint:   endpoint,
+		httpClient: utils.NewLogHttpClientWithTLS(logger, tlsConfig),
+	}, nil
+}
+
+func (s *S3) Name() string {
+	return s.name
+}
+
+// objectURL returns the url of an object, or of the bucket itself for an empty key.
+func (s *S3) objectURL(key string) *url.URL {
+	objectURL := *s.endpoint
+
+	path := "/" + key
+	if s.options.PathStyle {
+		path = strings.TrimSuffix("/"+s.options.Bucket+path, "/")
+	} else {
+		objectURL.Host = s.options.Bucket + "." + objectURL.Host
+	}
+
+	objectURL.Path = s.endpoint.Path + path
+	objectURL.RawPath = escapePath(objectURL.Path)
+
+	return &objectURL
+}
+
+// request sends a signed request for an object.
+func (s *S3) request(ctx context.Context, method, key, contentType string, body []byte) error {
+	req, err := http.NewRequestWithContext(ctx, method, s.objectURL(key).String(), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("could not create request: %v", err)
+	}
+
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+
+	s.signRequest(req, body, time.Now())
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("bad http response: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response: %v", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("received error code %d: %s", resp.StatusCode, string(respBytes))
+	}
+
+	return nil
+}
+
+// partition is the Hive style key prefix of a row, rows without a valid time are put in the hour they are shipped.
+func (s *S3) partition(stream sink.Stream, row map[string]string, now time.Time) string {
+	rowTime, err := time.Parse(time.RFC3339Nano, row["TimeGenerated"])
+	if err != nil {
+		rowTime = now
+	}
+
+	rowTime = rowTime.UTC()
+
+	return fmt.Sprintf("%stailnet=%s/type=%s/dt=%s/hour=%s/",
+		s.options.Prefix, url.PathEscape(s.options.Tailnet), stream, rowTime.Format("2006-01-02"), rowTime.Format("15"))
+}
+
+// object is a batch of rows of a single partition.
+type object struct {
+	key     string
+	payload bytes.Buffer
+	// rows are the indexes of the rows in the object
+	rows []int
+}
+
+// objects groups the rows by partition into objects under the maximum object size, keeping their order.
+func (s *S3) objects(stream sink.Stream, rows []map[string]string) ([]*object, error) {
+	now := time.Now()
+
+	var objects []*object
+	open := make(map[string]*object)
+
+	for i, row := range rows {
+		rowBytes, err := json.Marshal(row)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode row: %v", err)
+		}
+
+		partition := s.partition(stream, row, now)
+
+		current, ok := open[partition]
+		if !ok || current.payload.Len()+len(rowBytes)+1 > s.options.MaxObjectBytes {
+			current = &object{
+				key: fmt.Sprintf("%s%s-%s%s", partition, now.UTC().Format("20060102T150405Z"), uuid.NewString(), objectSuffix),
+			}
+
+			open[partition] = current
+			objects = append(objects, current)
+		}
+
+		current.payload.Write(rowBytes)
+		current.payload.WriteByte('\n')
+		current.rows = append(current.rows, i)
+	}
+
+	return objects, nil
+}
+
+func compress(payload []byte) ([]byte, error) {
+	var compressed bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(payload); err != nil {
+		return nil, fmt.Errorf("could not compress object: %v", err)
+	}
+	if err := gzipWriter.Close(); err != nil {
+		return nil, fmt.Errorf("could not compress object: %v", err)
+	}
+
+	return compressed.Bytes(), nil
+}
+
+// Write uploads the rows as objects and returns how many leading rows were archived.
+// Uploading stops at the first failed object, uploaded rows after the returned count are archived again by the next run.
+func (s *S3) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	logger := s.logger.WithField("module", "s3").WithField("sink", s.name).WithField("stream", stream)
+
+	objects, err := s.objects(stream, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.WithField("total", len(rows)).WithField("objects", len(objects)).Info("uploading objects")
+
+	// objects of different partitions interleave, so a row is archived once every object up to its own succeeded
+	uploaded := make([]bool, len(rows))
+
+	for _, obj := range objects {
+		payload, err := compress(obj.payload.Bytes())
+		if err != nil {
+			return leadingUploaded(uploaded), err
+		}
+
+		if err := s.request(ctx, http.MethodPut, obj.key, objectContentType, payload); err != nil {
+			return leadingUploaded(uploaded), fmt.Errorf("could not upload object '%s': %v", obj.key, err)
+		}
+
+		logger.WithField("key", obj.key).WithField("rows", len(obj.rows)).Debug("uploaded object")
+
+		for _, i := range obj.rows {
+			uploaded[i] = true
+		}
+	}
+
+	logger.WithField("total", len(rows)).Info("uploaded objects")
+
+	return len(rows), nil
+}
+
+func leadingUploaded(uploaded []bool) int {
+	for i, ok := range uploaded {
+		if !ok {
+			return i
+		}
+	}
+
+	return len(uploaded)
+}
+
+// Flush is a no-op since Write only returns once the objects were stored.
+func (s *S3) Flush(_ context.Context) error {
+	return nil
+}
+
+func (s *S3) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Health checks the bucket exists and the credentials can access it.
+func (s *S3) Health(ctx context.Context) error {
+	if err := s.request(ctx, http.MethodHead, "", "", nil); err != nil {
+		return fmt.Errorf("s3 bucket '%s' is unhealthy: %v", s.options.Bucket, err)
+	}
+
+	return nil
+}
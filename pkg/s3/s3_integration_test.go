@@ -0,0 +1,167 @@
+//go:build integration
+
+package s3
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"github.com/sirupsen/logrus/hooks/test"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestMinIO archives rows in a MinIO bucket, e.g. started with:
+//
+//	docker run -p 9000:9000 minio/minio server /data
+//	TAIL2SEN_MINIO_ENDPOINT=http://localhost:9000 go test -tags integration ./pkg/s3
+func TestMinIO(t *testing.T) {
+	endpoint := os.Getenv("TAIL2SEN_MINIO_ENDPOINT")
+	if endpoint == "" {
+		t.Skip("TAIL2SEN_MINIO_ENDPOINT is not set")
+	}
+
+	logger, hook := test.NewNullLogger()
+	logger.SetLevel(logrus.DebugLevel)
+
+	s3, err := New(logger, "s3", Options{
+		Endpoint:        endpoint,
+		Bucket:          envDefault("TAIL2SEN_MINIO_BUCKET", "tail2sen-test"),
+		PathStyle:       true,
+		AccessKeyID:     envDefault("TAIL2SEN_MINIO_ACCESS_KEY", "minioadmin"),
+		SecretAccessKey: envDefault("TAIL2SEN_MINIO_SECRET_KEY", "minioadmin"),
+		Prefix:          fmt.Sprintf("test-%d", time.Now().UnixNano()),
+		Tailnet:         "example.com",
+	})
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	defer s3.Close()
+
+	ctx := context.Background()
+
+	// creating an existing bucket fails, which the health check then tells apart from bad credentials
+	_ = s3.request(ctx, http.MethodPut, "", "", nil)
+
+	if err := s3.Health(ctx); err != nil {
+		t.Fatalf("bucket is unhealthy: %v", err)
+	}
+
+	rows := []map[string]string{
+		{"TimeGenerated": "2024-05-01T12:10:00Z", "EventId": "event-0"},
+		{"TimeGenerated": "2024-05-01T13:20:00Z", "EventId": "event-1"},
+		{"TimeGenerated": "2024-05-01T12:30:00Z", "EventId": "event-2"},
+	}
+
+	written, err := s3.Write(ctx, sink.StreamNetwork, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	// the object keys are unique per upload, so they are taken from the log instead of listing the bucket
+	objects := map[string][]string{}
+	for _, entry := range hook.AllEntries() {
+		if entry.Message == "uploaded object" {
+			key := entry.Data["key"].(string)
+			objects[key] = getEventIDs(t, s3, key)
+		}
+	}
+
+	expected := map[string][]string{
+		s3.options.Prefix + "tailnet=example.com/type=network/dt=2024-05-01/hour=12/": {"event-0", "event-2"},
+		s3.options.Prefix + "tailnet=example.com/type=network/dt=2024-05-01/hour=13/": {"event-1"},
+	}
+
+	if len(objects) != len(expected) {
+		t.Fatalf("uploaded %d objects, expected %d", len(objects), len(expected))
+	}
+
+	for key, ids := range objects {
+		partition := key[:strings.LastIndex(key, "/")+1]
+
+		if !strings.HasSuffix(key, objectSuffix) {
+			t.Errorf("object '%s' does not end with %s", key, objectSuffix)
+		}
+
+		if strings.Join(ids, ",") != strings.Join(expected[partition], ",") {
+			t.Errorf("object '%s' holds %v, expected %v", key, ids, expected[partition])
+		}
+	}
+}
+
+func envDefault(name, defaultValue string) string {
+	if value := os.Getenv(name); value != "" {
+		return value
+	}
+
+	return defaultValue
+}
+
+// getEventIDs downloads an object and returns the event ids of its gzipped NDJSON rows.
+func getEventIDs(t *testing.T, s3 *S3, key string) []string {
+	t.Helper()
+
+	req, err := http.NewRequest(http.MethodGet, s3.objectURL(key).String(), nil)
+	if err != nil {
+		t.Fatalf("could not create request: %v", err)
+	}
+
+	s3.signRequest(req, nil, time.Now())
+
+	resp, err := s3.httpClient.Do(req)
+	if err != nil {
+		t.Fatalf("could not get object '%s': %v", key, err)
+	}
+
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatalf("could not read object '%s': %v", key, err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("could not get object '%s': %d %s", key, resp.StatusCode, body)
+	}
+
+	if got := resp.Header.Get("Content-Type"); got != objectContentType {
+		t.Errorf("object '%s' has content type '%s'", key, got)
+	}
+
+	gzipReader, err := gzip.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatalf("object '%s' is not gzipped: %v", key, err)
+	}
+
+	var ids []string
+
+	scanner := bufio.NewScanner(gzipReader)
+	for scanner.Scan() {
+		var row map[string]string
+		if err := json.Unmarshal(scanner.Bytes(), &row); err != nil {
+			t.Fatalf("object '%s' holds an invalid row: %v", key, err)
+		}
+
+		ids = append(ids, row["EventId"])
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatalf("could not decompress object '%s': %v", key, err)
+	}
+
+	return ids
+}
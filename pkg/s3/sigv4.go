@@ -0,0 +1,96 @@
+package s3
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	signingAlgorithm = "AWS4-HMAC-SHA256"
+	signingService   = "s3"
+	amzDateFormat    = "20060102T150405Z"
+)
+
+func hashHex(data []byte) string {
+	hash := sha256.Sum256(data)
+	return hex.EncodeToString(hash[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// escapePath encodes an object path as AWS expects, keeping only unreserved characters and slashes.
+func escapePath(path string) string {
+	var builder strings.Builder
+
+	for _, b := range []byte(path) {
+		switch {
+		case b >= 'A' && b <= 'Z', b >= 'a' && b <= 'z', b >= '0' && b <= '9',
+			b == '-', b == '.', b == '_', b == '~', b == '/':
+			builder.WriteByte(b)
+		default:
+			builder.WriteString(fmt.Sprintf("%%%02X", b))
+		}
+	}
+
+	return builder.String()
+}
+
+// signRequest signs a request without query parameters with AWS Signature Version 4.
+func (s *S3) signRequest(req *http.Request, payload []byte, now time.Time) {
+	amzDate := now.UTC().Format(amzDateFormat)
+	date := amzDate[:8]
+	payloadHash := hashHex(payload)
+
+	req.Header.Set("X-Amz-Date", amzDate)
+	req.Header.Set("X-Amz-Content-Sha256", payloadHash)
+	if s.options.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", s.options.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	canonicalRequest := strings.Join([]string{
+		req.Method,
+		req.URL.EscapedPath(),
+		"",
+		canonicalHeaders.String(),
+		signedHeaders,
+		payloadHash,
+	}, "\n")
+
+	scope := strings.Join([]string{date, s.options.Region, signingService, "aws4_request"}, "/")
+	stringToSign := strings.Join([]string{signingAlgorithm, amzDate, scope, hashHex([]byte(canonicalRequest))}, "\n")
+
+	key := hmacSHA256([]byte("AWS4"+s.options.SecretAccessKey), date)
+	key = hmacSHA256(key, s.options.Region)
+	key = hmacSHA256(key, signingService)
+	key = hmacSHA256(key, "aws4_request")
+
+	req.Header.Set("Authorization", fmt.Sprintf("%s Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		signingAlgorithm, s.options.AccessKeyID, scope, signedHeaders, hex.EncodeToString(hmacSHA256(key, stringToSign))))
+}
@@ -0,0 +1,288 @@
+package sentinel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+const (
+	maxChunkSize = 1000 * 1000 // 1MB
+
+	// maxRecordSize is the largest single record that still fits in a chunk once wrapped in brackets
+	maxRecordSize = maxChunkSize - 2
+
+	// gzipOverhead is reserved for the gzip header, the final deflate block and the CRC/size trailer
+	gzipOverhead = 32
+)
+
+var (
+	// maxPolicyRecordSize is what the oversized policy shrinks records to, so that even a record that does not
+	// compress at all fits in a compressed chunk
+	maxPolicyRecordSize = maxRecordSize - (deflateBound(maxRecordSize) - maxRecordSize) - gzipOverhead
+)
+
+// logChunk is a JSON array of logs, optionally gzip compressed, that is ready to be uploaded as-is.
+type logChunk struct {
+	payload []byte
+	records int
+	// firstSource and lastSource are the indexes of the first and last input log with rows in this chunk
+	firstSource int
+	lastSource  int
+}
+
+// chunker incrementally encodes logs into JSON arrays that stay under the maximum chunk size.
+type chunker struct {
+	maxSize         int
+	maxUncompressed int
+	compress        bool
+
+	record  bytes.Buffer
+	encoder *json.Encoder
+
+	current      bytes.Buffer
+	gzipWriter   *gzip.Writer
+	uncompressed int
+	// pending is the amount of bytes written to the gzip writer that are not flushed into current yet
+	pending int
+	records int
+
+	// source is the index of the input log that is being added
+	source      int
+	firstSource int
+	lastSource  int
+
+	chunks []logChunk
+}
+
+func newChunker(maxSize int, compress bool) *chunker {
+	c := chunker{
+		maxSize:         maxSize,
+		maxUncompressed: maxSize,
+		compress:        compress,
+	}
+
+	c.encoder = json.NewEncoder(&c.record)
+
+	// the ingestion limit applies to the uncompressed payload too, the compressed size is checked as a second bound
+	// since gzip can grow data that does not compress
+	if compress {
+		c.gzipWriter = gzip.NewWriter(&c.current)
+	}
+
+	return &c
+}
+
+// deflateBound is the worst case size of n bytes after deflate, when everything ends up in stored blocks.
+func deflateBound(n int) int {
+	return n + 5*(n/16383+1)
+}
+
+func (c *chunker) write(p []byte) error {
+	c.uncompressed += len(p)
+
+	if !c.compress {
+		c.current.Write(p)
+		return nil
+	}
+
+	c.pending += len(p)
+
+	if _, err := c.gzipWriter.Write(p); err != nil {
+		return fmt.Errorf("could not compress log: %v", err)
+	}
+
+	return nil
+}
+
+// fits returns whether n more bytes and the closing bracket can be added to the current chunk.
+func (c *chunker) fits(n int) (bool, error) {
+	if c.uncompressed+n+1 > c.maxUncompressed {
+		return false, nil
+	}
+
+	if !c.compress {
+		return true, nil
+	}
+
+	if c.current.Len()+deflateBound(c.pending+n+1)+gzipOverhead <= c.maxSize {
+		return true, nil
+	}
+
+	if c.pending == 0 {
+		return false, nil
+	}
+
+	// the estimate is too pessimistic with buffered data, so flush it to learn the real compressed size
+	if err := c.gzipWriter.Flush(); err != nil {
+		return false, fmt.Errorf("could not flush compressed chunk: %v", err)
+	}
+
+	c.pending = 0
+
+	return c.current.Len()+deflateBound(n+1)+gzipOverhead <= c.maxSize, nil
+}
+
+// compressedSize returns the size of p once gzip compressed on its own.
+func compressedSize(p []byte) (int, error) {
+	var compressed bytes.Buffer
+
+	gzipWriter := gzip.NewWriter(&compressed)
+	if _, err := gzipWriter.Write(p); err != nil {
+		return 0, fmt.Errorf("could not compress log: %v", err)
+	}
+
+	if err := gzipWriter.Close(); err != nil {
+		return 0, fmt.Errorf("could not compress log: %v", err)
+	}
+
+	return compressed.Len(), nil
+}
+
+// fitsAlone returns whether an encoded record fits in a compressed chunk of its own.
+// Only records that could exceed the chunk size in the worst case are compressed to find out.
+func (c *chunker) fitsAlone(encoded []byte) (bool, error) {
+	if !c.compress || deflateBound(len(encoded)+2)+gzipOverhead <= c.maxSize {
+		return true, nil
+	}
+
+	size, err := compressedSize(append(append([]byte{'['}, encoded...), ']'))
+	if err != nil {
+		return false, err
+	}
+
+	// the margin covers the sync flush of a chunk that is cut once the next record does not fit
+	return size+gzipOverhead <= c.maxSize, nil
+}
+
+func (c *chunker) flush() error {
+	if c.records == 0 {
+		return nil
+	}
+
+	if err := c.write([]byte{']'}); err != nil {
+		return err
+	}
+
+	if c.compress {
+		if err := c.gzipWriter.Close(); err != nil {
+			return fmt.Errorf("could not close compressed chunk: %v", err)
+		}
+
+		if c.current.Len() > c.maxSize {
+			return fmt.Errorf("compressed chunk of %d bytes exceeds max chunk size", c.current.Len())
+		}
+	}
+
+	c.chunks = append(c.chunks, logChunk{
+		payload:     bytes.Clone(c.current.Bytes()),
+		records:     c.records,
+		firstSource: c.firstSource,
+		lastSource:  c.lastSource,
+	})
+
+	c.current.Reset()
+	c.uncompressed = 0
+	c.pending = 0
+	c.records = 0
+
+	if c.compress {
+		c.gzipWriter.Reset(&c.current)
+	}
+
+	return nil
+}
+
+// add encodes a single row and appends it to the current chunk, cutting a new chunk when it would not fit.
+func (c *chunker) add(logEntry map[string]string) error {
+	c.record.Reset()
+
+	if err := c.encoder.Encode(logEntry); err != nil {
+		return fmt.Errorf("could not json encode log: %v", err)
+	}
+
+	// the encoder terminates every value with a newline which is not part of the array
+	encoded := bytes.TrimSuffix(c.record.Bytes(), []byte{'\n'})
+
+	if len(encoded) > maxRecordSize || len(encoded)+2 > c.maxUncompressed {
+		return fmt.Errorf("%w: %d bytes", errRecordTooLarge, len(encoded))
+	}
+
+	fitsAlone, err := c.fitsAlone(encoded)
+	if err != nil {
+		return err
+	}
+
+	if !fitsAlone {
+		return fmt.Errorf("%w: %d bytes after compression", errRecordTooLarge, len(encoded))
+	}
+
+	if c.records > 0 {
+		// account for the separating comma
+		fits, err := c.fits(len(encoded) + 1)
+		if err != nil {
+			return err
+		}
+
+		if !fits {
+			if err := c.flush(); err != nil {
+				return err
+			}
+		}
+	}
+
+	separator := []byte{','}
+	if c.records == 0 {
+		separator = []byte{'['}
+		c.firstSource = c.source
+	}
+
+	if err := c.write(separator); err != nil {
+		return err
+	}
+
+	if err := c.write(encoded); err != nil {
+		return err
+	}
+
+	c.records++
+	c.lastSource = c.source
+
+	return nil
+}
+
+// chunkLogs encodes the logs into chunks, applying the oversized policy to logs that do not fit on their own.
+func (s *Sentinel) chunkLogs(streamName string, slice []map[string]string, compress bool, oversizedPolicy string) ([]logChunk, error) {
+	c := newChunker(maxChunkSize, compress)
+
+	for i, logEntry := range slice {
+		c.source = i
+
+		err := c.add(logEntry)
+		if errors.Is(err, errRecordTooLarge) {
+			rows, handleErr := s.handleOversized(oversizedPolicy, streamName, logEntry)
+			if handleErr != nil {
+				return nil, fmt.Errorf("could not handle oversized log: %v", handleErr)
+			}
+
+			err = nil
+			for _, row := range rows {
+				if err = c.add(row); err != nil {
+					break
+				}
+			}
+		}
+
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := c.flush(); err != nil {
+		return nil, err
+	}
+
+	return c.chunks, nil
+}
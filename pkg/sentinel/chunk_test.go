@@ -0,0 +1,241 @@
+package sentinel
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// sizedRecord returns a record that encodes to exactly size bytes.
+func sizedRecord(size int) map[string]string {
+	// {"v":""} is 8 bytes
+	return map[string]string{"v": strings.Repeat("x", size-8)}
+}
+
+// randomRecord returns a record of about size bytes that barely compresses.
+func randomRecord(random *rand.Rand, size int) map[string]string {
+	const alphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789!#$%&()*+,-./:;<=>?@[]^_{|}~"
+
+	value := make([]byte, size-8)
+	for i := range value {
+		value[i] = alphabet[random.Intn(len(alphabet))]
+	}
+
+	return map[string]string{"v": string(value)}
+}
+
+// decodeChunk returns the rows of a chunk, decompressing it when gzipped.
+func decodeChunk(t *testing.T, chunk logChunk, compressed bool) []map[string]string {
+	t.Helper()
+
+	payload := chunk.payload
+	if compressed {
+		gzipReader, err := gzip.NewReader(bytes.NewReader(payload))
+		if err != nil {
+			t.Fatalf("chunk is not gzipped: %v", err)
+		}
+
+		if payload, err = io.ReadAll(gzipReader); err != nil {
+			t.Fatalf("could not decompress chunk: %v", err)
+		}
+	}
+
+	var rows []map[string]string
+	if err := json.Unmarshal(payload, &rows); err != nil {
+		t.Fatalf("chunk is not a JSON array: %v", err)
+	}
+
+	if len(rows) != chunk.records {
+		t.Fatalf("chunk holds %d rows but counts %d", len(rows), chunk.records)
+	}
+
+	return rows
+}
+
+func addAll(t *testing.T, c *chunker, records ...map[string]string) {
+	t.Helper()
+
+	for i, record := range records {
+		c.source = i
+
+		if err := c.add(record); err != nil {
+			t.Fatalf("could not add record %d: %v", i, err)
+		}
+	}
+
+	if err := c.flush(); err != nil {
+		t.Fatalf("could not flush: %v", err)
+	}
+}
+
+func TestChunkerBoundaries(t *testing.T) {
+	tests := []struct {
+		name   string
+		sizes  []int
+		chunks []int
+	}{
+		// [ + 97 + ] is exactly 100 bytes
+		{name: "single record at the limit", sizes: []int{98}, chunks: []int{1}},
+		// [ + 48 + , + 49 + ] is exactly 100 bytes
+		{name: "records up to the limit", sizes: []int{48, 49}, chunks: []int{2}},
+		{name: "one byte over the limit", sizes: []int{48, 50}, chunks: []int{1, 1}},
+		{name: "several chunks", sizes: []int{40, 40, 40, 40, 40}, chunks: []int{2, 2, 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newChunker(100, false)
+
+			records := make([]map[string]string, len(test.sizes))
+			for i, size := range test.sizes {
+				records[i] = sizedRecord(size)
+			}
+
+			addAll(t, c, records...)
+
+			if len(c.chunks) != len(test.chunks) {
+				t.Fatalf("got %d chunks, expected %d", len(c.chunks), len(test.chunks))
+			}
+
+			for i, chunk := range c.chunks {
+				if len(chunk.payload) > 100 {
+					t.Errorf("chunk %d is %d bytes, over the max of 100", i, len(chunk.payload))
+				}
+
+				if chunk.records != test.chunks[i] {
+					t.Errorf("chunk %d holds %d records, expected %d", i, chunk.records, test.chunks[i])
+				}
+
+				decodeChunk(t, chunk, false)
+			}
+		})
+	}
+}
+
+func TestChunkerRejectsOversizedRecords(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	tests := []struct {
+		name     string
+		compress bool
+		record   map[string]string
+	}{
+		{name: "uncompressed", record: sizedRecord(99)},
+		// the record fits uncompressed, but gzip adds its header and trailer to data that does not compress
+		{name: "compressed", compress: true, record: randomRecord(random, 90)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c := newChunker(100, test.compress)
+
+			if err := c.add(test.record); !errors.Is(err, errRecordTooLarge) {
+				t.Fatalf("expected the record to be too large, got %v", err)
+			}
+
+			if err := c.flush(); err != nil {
+				t.Fatalf("a rejected record failed the chunk: %v", err)
+			}
+
+			if len(c.chunks) != 0 {
+				t.Fatalf("expected no chunks, got %d", len(c.chunks))
+			}
+		})
+	}
+}
+
+func TestChunkLogs(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	var logs []map[string]string
+	for i := range 300 {
+		logs = append(logs, map[string]string{
+			"EventId": fmt.Sprintf("event-%d", i),
+			"Action":  randomRecord(random, 5000)["v"],
+		})
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress %v", compress), func(t *testing.T) {
+			chunks, err := newTestSentinel(t, nil).chunkLogs("Custom-Stream", logs, compress, OversizedTruncate)
+			if err != nil {
+				t.Fatalf("could not chunk logs: %v", err)
+			}
+
+			if len(chunks) < 2 {
+				t.Fatalf("expected several chunks, got %d", len(chunks))
+			}
+
+			next := 0
+			for i, chunk := range chunks {
+				if len(chunk.payload) > maxChunkSize {
+					t.Errorf("chunk %d is %d bytes, over the max of %d", i, len(chunk.payload), maxChunkSize)
+				}
+
+				if chunk.firstSource != next {
+					t.Errorf("chunk %d starts at log %d, expected %d", i, chunk.firstSource, next)
+				}
+
+				for _, row := range decodeChunk(t, chunk, compress) {
+					if expected := fmt.Sprintf("event-%d", next); row["EventId"] != expected {
+						t.Fatalf("chunk %d holds '%s' where '%s' was expected", i, row["EventId"], expected)
+					}
+
+					next++
+				}
+
+				if chunk.lastSource != next-1 {
+					t.Errorf("chunk %d ends at log %d, expected %d", i, chunk.lastSource, next-1)
+				}
+			}
+
+			if next != len(logs) {
+				t.Fatalf("chunks hold %d logs, expected %d", next, len(logs))
+			}
+		})
+	}
+}
+
+func TestChunkLogsOversizedIncompressible(t *testing.T) {
+	random := rand.New(rand.NewSource(1))
+
+	logs := []map[string]string{
+		{"EventId": "event-0", "Action": "small"},
+		{"EventId": "event-1", "TimeGenerated": "2024-05-01T12:00:00Z", "Action": randomRecord(random, maxRecordSize+1000)["v"]},
+		{"EventId": "event-2", "Action": "small"},
+	}
+
+	for _, compress := range []bool{false, true} {
+		t.Run(fmt.Sprintf("compress %v", compress), func(t *testing.T) {
+			chunks, err := newTestSentinel(t, nil).chunkLogs("Custom-Stream", logs, compress, OversizedTruncate)
+			if err != nil {
+				t.Fatalf("could not chunk logs: %v", err)
+			}
+
+			var ids []string
+			for i, chunk := range chunks {
+				if len(chunk.payload) > maxChunkSize {
+					t.Errorf("chunk %d is %d bytes, over the max of %d", i, len(chunk.payload), maxChunkSize)
+				}
+
+				for _, row := range decodeChunk(t, chunk, compress) {
+					ids = append(ids, row["EventId"])
+
+					if row["EventId"] == "event-1" && !strings.HasSuffix(row["Action"], truncatedMarker) {
+						t.Error("the oversized log was not truncated")
+					}
+				}
+			}
+
+			if strings.Join(ids, ",") != "event-0,event-1,event-2" {
+				t.Fatalf("chunks hold %v, expected the logs in order", ids)
+			}
+		})
+	}
+}
@@ -0,0 +1,41 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/arm"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+)
+
+const (
+	// CloudPublic is the global Azure cloud.
+	CloudPublic = "public"
+	// CloudGovernment is Azure Government.
+	CloudGovernment = "government"
+	// CloudChina is Azure operated by 21Vianet.
+	CloudChina = "china"
+)
+
+// cloudConfiguration returns the authority host and service endpoints of a cloud, defaulting to the public cloud.
+func cloudConfiguration(name string) (cloud.Configuration, error) {
+	switch name {
+	case "", CloudPublic:
+		return cloud.AzurePublic, nil
+	case CloudGovernment:
+		return cloud.AzureGovernment, nil
+	case CloudChina:
+		return cloud.AzureChina, nil
+	default:
+		return cloud.Configuration{}, fmt.Errorf("unknown azure cloud '%s'", name)
+	}
+}
+
+// clientOptions points data plane clients and credentials at the configured cloud.
+func (s *Sentinel) clientOptions() azcore.ClientOptions {
+	return azcore.ClientOptions{Cloud: s.cloud}
+}
+
+// armOptions points resource manager clients at the configured cloud.
+func (s *Sentinel) armOptions() *arm.ClientOptions {
+	return &arm.ClientOptions{ClientOptions: s.clientOptions()}
+}
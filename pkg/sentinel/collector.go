@@ -0,0 +1,125 @@
+package sentinel
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"regexp"
+	"time"
+)
+
+const (
+	collectorAPIVersion  = "2016-04-01"
+	collectorResource    = "/api/logs"
+	collectorContentType = "application/json"
+
+	defaultCollectorTimeField = "TimeGenerated"
+)
+
+var (
+	// collectorDomains are the HTTP Data Collector API domains per cloud
+	collectorDomains = map[string]string{
+		"":              "ods.opinsights.azure.com",
+		CloudPublic:     "ods.opinsights.azure.com",
+		CloudGovernment: "ods.opinsights.azure.us",
+		CloudChina:      "ods.opinsights.azure.cn",
+	}
+
+	logTypePattern = regexp.MustCompile(`^[A-Za-z0-9_]{1,100}$`)
+)
+
+// CollectorDestination is a workspace that receives logs through the legacy HTTP Data Collector API.
+// Logs land in the LogType_CL table, with the column type appended to every column name.
+type CollectorDestination struct {
+	WorkspaceID string
+	// SharedKey is the base64 encoded primary or secondary key of the workspace.
+	SharedKey string
+	LogType   string
+	// TimeField is the field holding the event time, defaults to TimeGenerated.
+	TimeField string
+}
+
+// collectorSignature signs a request with the SharedKey scheme of the HTTP Data Collector API.
+func collectorSignature(workspaceID string, key []byte, date string, contentLength int) string {
+	stringToSign := fmt.Sprintf("%s\n%d\n%s\nx-ms-date:%s\n%s",
+		http.MethodPost, contentLength, collectorContentType, date, collectorResource)
+
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(stringToSign))
+
+	return fmt.Sprintf("SharedKey %s:%s", workspaceID, base64.StdEncoding.EncodeToString(mac.Sum(nil)))
+}
+
+// postCollectorChunk uploads a JSON array of logs to the HTTP Data Collector API.
+func (s *Sentinel) postCollectorChunk(ctx context.Context, destination CollectorDestination, key []byte, payload []byte) error {
+	url := fmt.Sprintf("https://%s.%s%s?api-version=%s",
+		destination.WorkspaceID, collectorDomains[s.creds.Cloud], collectorResource, collectorAPIVersion)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(payload))
+	if err != nil {
+		return fmt.Errorf("could not create request: %v", err)
+	}
+
+	date := time.Now().UTC().Format(http.TimeFormat)
+
+	req.Header.Set("Content-Type", collectorContentType)
+	req.Header.Set("Log-Type", destination.LogType)
+	req.Header.Set("x-ms-date", date)
+	req.Header.Set("time-generated-field", destination.TimeField)
+	req.Header.Set("Authorization", collectorSignature(destination.WorkspaceID, key, date, len(payload)))
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("could not post logs: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	if resp.StatusCode > 299 {
+		respBytes, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("received error code %d: %s", resp.StatusCode, string(respBytes))
+	}
+
+	return nil
+}
+
+// SendCollectorLogs ships the logs to a workspace through the legacy HTTP Data Collector API.
+// It chunks and reports progress like SendLogs, but failed chunks are never spooled since the key is not stored.
+func (s *Sentinel) SendCollectorLogs(ctx context.Context, l *logrus.Logger, destination CollectorDestination, logs []map[string]string) (int, error) {
+	return s.sendCollectorLogs(ctx, l, destination, logs, s.options.OversizedPolicy)
+}
+
+// sendCollectorLogs is SendCollectorLogs with the oversized policy to apply, see sendLogs.
+func (s *Sentinel) sendCollectorLogs(ctx context.Context, l *logrus.Logger, destination CollectorDestination, logs []map[string]string, oversizedPolicy string) (int, error) {
+	if destination.WorkspaceID == "" {
+		return 0, fmt.Errorf("no workspace id provided")
+	}
+
+	if !logTypePattern.MatchString(destination.LogType) {
+		return 0, fmt.Errorf("invalid log type '%s', only letters, numbers and underscores are allowed", destination.LogType)
+	}
+
+	if destination.TimeField == "" {
+		destination.TimeField = defaultCollectorTimeField
+	}
+
+	key, err := base64.StdEncoding.DecodeString(destination.SharedKey)
+	if err != nil {
+		return 0, fmt.Errorf("could not decode shared key: %v", err)
+	}
+
+	// the data collector api does not accept compressed payloads
+	return s.sendChunked(ctx, l, destination.LogType, logs, false, chunkUploader{
+		limiterKey:      destination.WorkspaceID,
+		oversizedPolicy: oversizedPolicy,
+		upload: func(ctx context.Context, chunk logChunk) error {
+			return s.postCollectorChunk(ctx, destination, key, chunk.payload)
+		},
+	})
+}
@@ -0,0 +1,24 @@
+package sentinel
+
+import (
+	"encoding/base64"
+	"testing"
+)
+
+func TestCollectorSignature(t *testing.T) {
+	// computed independently with:
+	// printf 'POST\n11\napplication/json\nx-ms-date:Mon, 01 Jan 2024 00:00:00 GMT\n/api/logs' |
+	//   openssl dgst -sha256 -hmac 'tail2sen-test-shared-key' -binary | base64
+	const expected = "SharedKey workspace:0F2LXexU1vIvOIrzue/N4kdhqmz9yQNVXGEXKQAyWVU="
+
+	key, err := base64.StdEncoding.DecodeString("dGFpbDJzZW4tdGVzdC1zaGFyZWQta2V5")
+	if err != nil {
+		t.Fatalf("could not decode key: %v", err)
+	}
+
+	body := `[{"a":"b"}]`
+
+	if signature := collectorSignature("workspace", key, "Mon, 01 Jan 2024 00:00:00 GMT", len(body)); signature != expected {
+		t.Fatalf("got signature '%s', expected '%s'", signature, expected)
+	}
+}
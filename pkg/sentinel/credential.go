@@ -0,0 +1,115 @@
+package sentinel
+
+import (
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"os"
+)
+
+const (
+	// AuthSecret authenticates as an app registration with a client secret.
+	AuthSecret = "secret"
+	// AuthCertificate authenticates as an app registration with a PEM or PKCS12 client certificate.
+	AuthCertificate = "certificate"
+	// AuthManagedIdentity uses the system assigned, or the configured user assigned, managed identity.
+	AuthManagedIdentity = "managed_identity"
+	// AuthWorkloadIdentity exchanges a federated token file, as mounted by AKS workload identity.
+	AuthWorkloadIdentity = "workload_identity"
+	// AuthCLI uses the account logged in with the Azure CLI, meant for local development.
+	// The CLI picks its cloud itself with 'az cloud set'.
+	AuthCLI = "cli"
+	// AuthDefault uses the default azure credential chain, configured through the environment.
+	AuthDefault = "default"
+)
+
+// newCredential builds the credential of every configured method, chaining them when there is more than one.
+// The client options select the authority host of the cloud.
+func newCredential(creds Credentials, options azcore.ClientOptions) (azcore.TokenCredential, error) {
+	methods := creds.Methods
+	if len(methods) == 0 {
+		methods = []string{AuthSecret}
+	}
+
+	sources := make([]azcore.TokenCredential, 0, len(methods))
+
+	for _, method := range methods {
+		source, err := newMethodCredential(creds, method, options)
+		if err != nil {
+			return nil, fmt.Errorf("could not create %s credential: %v", method, err)
+		}
+
+		sources = append(sources, source)
+	}
+
+	if len(sources) == 1 {
+		return sources[0], nil
+	}
+
+	chain, err := azidentity.NewChainedTokenCredential(sources, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not chain credentials: %v", err)
+	}
+
+	return chain, nil
+}
+
+func newMethodCredential(creds Credentials, method string, options azcore.ClientOptions) (azcore.TokenCredential, error) {
+	switch method {
+	case AuthSecret:
+		return azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret, &azidentity.ClientSecretCredentialOptions{
+			ClientOptions: options,
+		})
+
+	case AuthCertificate:
+		certData, err := os.ReadFile(creds.CertificatePath)
+		if err != nil {
+			return nil, fmt.Errorf("could not read certificate '%s': %v", creds.CertificatePath, err)
+		}
+
+		var password []byte
+		if creds.CertificatePassword != "" {
+			password = []byte(creds.CertificatePassword)
+		}
+
+		certs, key, err := azidentity.ParseCertificates(certData, password)
+		if err != nil {
+			return nil, fmt.Errorf("could not parse certificate '%s': %v", creds.CertificatePath, err)
+		}
+
+		return azidentity.NewClientCertificateCredential(creds.TenantID, creds.ClientID, certs, key, &azidentity.ClientCertificateCredentialOptions{
+			ClientOptions: options,
+		})
+
+	case AuthManagedIdentity:
+		identityOptions := azidentity.ManagedIdentityCredentialOptions{ClientOptions: options}
+		if creds.ManagedIdentityClientID != "" {
+			identityOptions.ID = azidentity.ClientID(creds.ManagedIdentityClientID)
+		}
+
+		return azidentity.NewManagedIdentityCredential(&identityOptions)
+
+	case AuthWorkloadIdentity:
+		// empty values fall back to the AZURE_CLIENT_ID, AZURE_TENANT_ID and AZURE_FEDERATED_TOKEN_FILE variables
+		return azidentity.NewWorkloadIdentityCredential(&azidentity.WorkloadIdentityCredentialOptions{
+			ClientOptions: options,
+			ClientID:      creds.ClientID,
+			TenantID:      creds.TenantID,
+			TokenFilePath: creds.FederatedTokenFile,
+		})
+
+	case AuthCLI:
+		return azidentity.NewAzureCLICredential(&azidentity.AzureCLICredentialOptions{
+			TenantID: creds.TenantID,
+		})
+
+	case AuthDefault:
+		return azidentity.NewDefaultAzureCredential(&azidentity.DefaultAzureCredentialOptions{
+			ClientOptions: options,
+			TenantID:      creds.TenantID,
+		})
+
+	default:
+		return nil, fmt.Errorf("unknown authentication method '%s'", method)
+	}
+}
@@ -0,0 +1,185 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/runtime"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"github.com/sirupsen/logrus"
+	"strings"
+	"time"
+)
+
+const (
+	// DriftWarn logs schema drift but keeps shipping.
+	DriftWarn = "warn"
+	// DriftFail aborts when the live table does not match the schema.
+	DriftFail = "fail"
+	// DriftAdd adds missing columns to the live table, other drift is logged.
+	DriftAdd = "add"
+)
+
+// ColumnMismatch is a column that exists in the live table with a different type.
+type ColumnMismatch struct {
+	Name     string
+	Expected insights.ColumnTypeEnum
+	Actual   insights.ColumnTypeEnum
+}
+
+// SchemaDrift describes how a live table differs from its schema.
+type SchemaDrift struct {
+	TableName string
+	// Missing columns are declared in the schema but not in the table, their data is dropped on ingestion.
+	Missing []Column
+	// Extra columns exist in the table but are not emitted by tail2sen.
+	Extra []Column
+	// Mismatched columns have a different type in the table.
+	Mismatched []ColumnMismatch
+
+	// existing are the custom columns of the live table, needed to update it
+	existing []*insights.Column
+}
+
+func (d *SchemaDrift) HasDrift() bool {
+	return len(d.Missing) > 0 || len(d.Extra) > 0 || len(d.Mismatched) > 0
+}
+
+func (d *SchemaDrift) String() string {
+	var parts []string
+
+	for _, column := range d.Missing {
+		parts = append(parts, fmt.Sprintf("missing %s (%s)", column.Name, column.Type))
+	}
+
+	for _, column := range d.Extra {
+		parts = append(parts, fmt.Sprintf("extra %s (%s)", column.Name, column.Type))
+	}
+
+	for _, mismatch := range d.Mismatched {
+		parts = append(parts, fmt.Sprintf("%s is %s instead of %s", mismatch.Name, mismatch.Actual, mismatch.Expected))
+	}
+
+	if len(parts) == 0 {
+		return fmt.Sprintf("table '%s' matches its schema", d.TableName)
+	}
+
+	return fmt.Sprintf("table '%s' drifted: %s", d.TableName, strings.Join(parts, ", "))
+}
+
+func columnType(column *insights.Column) insights.ColumnTypeEnum {
+	if column == nil || column.Type == nil {
+		return ""
+	}
+
+	return *column.Type
+}
+
+// CheckSchemaDrift compares the live table of a schema with the columns tail2sen emits.
+func (s *Sentinel) CheckSchemaDrift(ctx context.Context, l *logrus.Logger, workspace Workspace, schema *Schema) (*SchemaDrift, error) {
+	logger := l.WithField("module", "sentinel_drift")
+
+	logger.WithField("table_name", schema.TableName).Debug("checking table schema")
+
+	resp, err := s.tablesClient.Get(ctx, workspace.ResourceGroup, workspace.Name, schema.TableName, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not get table '%s': %v", schema.TableName, err)
+	}
+
+	if resp.Properties == nil || resp.Properties.Schema == nil {
+		return nil, fmt.Errorf("table '%s' has no schema", schema.TableName)
+	}
+
+	drift := SchemaDrift{
+		TableName: schema.TableName,
+		existing:  resp.Properties.Schema.Columns,
+	}
+
+	actual := make(map[string]insights.ColumnTypeEnum)
+	for _, column := range resp.Properties.Schema.Columns {
+		if column != nil && column.Name != nil {
+			actual[*column.Name] = columnType(column)
+		}
+	}
+
+	// standard columns such as TimeGenerated are managed by azure and never extra
+	standard := make(map[string]insights.ColumnTypeEnum)
+	for _, column := range resp.Properties.Schema.StandardColumns {
+		if column != nil && column.Name != nil {
+			standard[*column.Name] = columnType(column)
+		}
+	}
+
+	for _, column := range schema.Columns {
+		actualType, ok := actual[column.Name]
+		if !ok {
+			actualType, ok = standard[column.Name]
+		}
+
+		switch {
+		case !ok:
+			drift.Missing = append(drift.Missing, column)
+		case !strings.EqualFold(string(actualType), string(column.Type)):
+			drift.Mismatched = append(drift.Mismatched, ColumnMismatch{
+				Name:     column.Name,
+				Expected: column.Type,
+				Actual:   actualType,
+			})
+		}
+	}
+
+	for _, column := range resp.Properties.Schema.Columns {
+		if column == nil || column.Name == nil {
+			continue
+		}
+
+		if _, ok := schema.Column(*column.Name); !ok {
+			drift.Extra = append(drift.Extra, Column{Name: *column.Name, Type: columnType(column)})
+		}
+	}
+
+	return &drift, nil
+}
+
+// AddMissingColumns appends the missing columns of a drift report to the live table, leaving other columns untouched.
+func (s *Sentinel) AddMissingColumns(ctx context.Context, l *logrus.Logger, workspace Workspace, drift *SchemaDrift) error {
+	logger := l.WithField("module", "sentinel_drift")
+
+	if len(drift.Missing) == 0 {
+		return nil
+	}
+
+	columns := append([]*insights.Column{}, drift.existing...)
+	for _, column := range drift.Missing {
+		columns = append(columns, &insights.Column{
+			Name: to.Ptr[string](column.Name),
+			Type: to.Ptr[insights.ColumnTypeEnum](column.Type),
+		})
+	}
+
+	logger.WithField("table_name", drift.TableName).WithField("missing", len(drift.Missing)).Info("adding missing columns")
+
+	poller, err := s.tablesClient.BeginUpdate(ctx, workspace.ResourceGroup, workspace.Name, drift.TableName,
+		insights.Table{
+			Properties: &insights.TableProperties{
+				Schema: &insights.Schema{
+					Name:    to.Ptr[string](drift.TableName),
+					Columns: columns,
+				},
+			},
+		}, nil)
+	if err != nil {
+		return fmt.Errorf("could not update table '%s': %v", drift.TableName, err)
+	}
+
+	if _, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: time.Second}); err != nil {
+		return fmt.Errorf("could not poll table update: %v", err)
+	}
+
+	logger.WithField("table_name", drift.TableName).Info("added missing columns")
+
+	drift.existing = columns
+	drift.Missing = nil
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package sentinel
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"path"
+	"strings"
+)
+
+const (
+	functionCategory = "Tailscale"
+	functionIDPrefix = "tail2sen-"
+)
+
+var (
+	//go:embed functions/*.yaml
+	functionFiles embed.FS
+)
+
+// FunctionTemplate is a KQL function bundled with tail2sen, saved in the workspace under its alias.
+type FunctionTemplate struct {
+	Name        string `yaml:"name"`
+	Source      string `yaml:"source"`
+	DisplayName string `yaml:"displayName"`
+	// Parameters are optional function parameters in the 'name:type = default' syntax.
+	Parameters string `yaml:"parameters"`
+	// Query is a text/template rendered with TemplateParameters.
+	Query string `yaml:"query"`
+}
+
+// FunctionTemplates returns the bundled KQL functions in file name order, so parsers are saved before the functions using them.
+func FunctionTemplates() ([]FunctionTemplate, error) {
+	paths, err := functionFiles.ReadDir("functions")
+	if err != nil {
+		return nil, fmt.Errorf("could not list function templates: %v", err)
+	}
+
+	names := make(map[string]struct{}, len(paths))
+	templates := make([]FunctionTemplate, 0, len(paths))
+
+	for _, entry := range paths {
+		fileBytes, err := functionFiles.ReadFile(path.Join("functions", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read function template '%s': %v", entry.Name(), err)
+		}
+
+		var function FunctionTemplate
+		if err := yaml.Unmarshal(fileBytes, &function); err != nil {
+			return nil, fmt.Errorf("could not decode function template '%s': %v", entry.Name(), err)
+		}
+
+		if function.Name == "" || function.Query == "" {
+			return nil, fmt.Errorf("function template '%s' misses a name or query", entry.Name())
+		}
+
+		if function.Source != RuleSourceAudit && function.Source != RuleSourceNetwork {
+			return nil, fmt.Errorf("function template '%s' has unknown source '%s'", entry.Name(), function.Source)
+		}
+
+		if _, ok := names[function.Name]; ok {
+			return nil, fmt.Errorf("duplicate function template name '%s'", function.Name)
+		}
+		names[function.Name] = struct{}{}
+
+		templates = append(templates, function)
+	}
+
+	return templates, nil
+}
+
+// DeployFunction saves a KQL function in the workspace, replacing an earlier version with the same alias.
+func (s *Sentinel) DeployFunction(ctx context.Context, l *logrus.Logger, workspace Workspace, function FunctionTemplate, params TemplateParameters) error {
+	logger := l.WithField("module", "sentinel_functions").WithField("function_name", function.Name)
+
+	query, err := renderTemplate(function.Name, function.Query, params)
+	if err != nil {
+		return fmt.Errorf("could not render query of function '%s': %v", function.Name, err)
+	}
+
+	displayName := function.DisplayName
+	if displayName == "" {
+		displayName = function.Name
+	}
+
+	properties := insights.SavedSearchProperties{
+		Category:      to.Ptr(functionCategory),
+		DisplayName:   to.Ptr(displayName),
+		Query:         to.Ptr(query),
+		FunctionAlias: to.Ptr(function.Name),
+		Version:       to.Ptr[int64](2),
+	}
+
+	if function.Parameters != "" {
+		properties.FunctionParameters = to.Ptr(function.Parameters)
+	}
+
+	logger.Info("creating or updating function")
+
+	if _, err := s.savedSearchesClient.CreateOrUpdate(ctx, workspace.ResourceGroup, workspace.Name,
+		functionIDPrefix+strings.ToLower(function.Name), insights.SavedSearch{Properties: &properties}, nil); err != nil {
+		return fmt.Errorf("could not deploy function '%s': %v", function.Name, err)
+	}
+
+	logger.Info("deployed function")
+
+	return nil
+}
@@ -2,37 +2,40 @@ package sentinel
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
 	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
 	"github.com/sirupsen/logrus"
 )
 
-func (s *Sentinel) IngestLog(ctx context.Context, endpoint, ruleID, streamName string, logs []map[string]string) error {
+// IngestLog uploads a JSON array of logs that was encoded, and optionally gzip compressed, by the chunker.
+func (s *Sentinel) IngestLog(ctx context.Context, endpoint, ruleID, streamName string, logPayload []byte, records int, compressed bool) error {
 	logger := s.logger.WithField("module", "sentinel_ingest")
 
-	ingest, err := azlogs.NewClient(endpoint, s.azCreds, nil)
+	ingest, err := s.ingestClient(endpoint)
 	if err != nil {
-		return fmt.Errorf("could not create azure ingest client: %v", err)
+		return err
 	}
 
-	logPayload, err := json.Marshal(&logs)
-	if err != nil {
-		return fmt.Errorf("could not json encode log message: %v", err)
-	}
+	var uploadOptions *azlogs.UploadOptions
 
-	if s.logger.IsLevelEnabled(logrus.TraceLevel) {
-		logger.Tracef("%s", string(logPayload))
+	if !compressed {
+		if s.logger.IsLevelEnabled(logrus.TraceLevel) {
+			logger.Tracef("%s", string(logPayload))
+		}
+	} else {
+		uploadOptions = &azlogs.UploadOptions{ContentEncoding: to.Ptr("gzip")}
 	}
 
-	logger.WithField("logs", len(logs)).Debug("uploading log")
+	logger.WithField("logs", records).WithField("bytes", len(logPayload)).Debug("uploading log")
 
-	_, err = ingest.Upload(ctx, ruleID, streamName, logPayload, nil)
+	_, err = ingest.Upload(ctx, ruleID, streamName, logPayload, uploadOptions)
 	if err != nil {
-		return fmt.Errorf("could not upload logs: %v", err)
+		// wrapped so the status code can be classified when retrying dead-letter chunks
+		return fmt.Errorf("could not upload logs: %w", err)
 	}
 
-	logger.WithField("total_logs", len(logs)).Debug("successfully uploaded 1password logs")
+	logger.WithField("total_logs", records).Debug("successfully uploaded 1password logs")
 
 	return nil
 }
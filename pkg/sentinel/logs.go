@@ -2,88 +2,184 @@ package sentinel
 
 import (
 	"context"
-	"encoding/json"
 	"fmt"
 	"github.com/sirupsen/logrus"
+	"strings"
+	"sync"
 )
 
-const (
-	maxChunkSize = 1000 * 1000 // 1MB
-)
+// ChunkError describes a chunk that could not be shipped.
+type ChunkError struct {
+	Index   int
+	Records int
+	Err     error
+}
 
-// estimateSize estimates the size of a log batch in bytes
-func (s *Sentinel) estimateSize(logs []map[string]string) (int, error) {
-	data, err := json.Marshal(logs)
-	if err != nil {
-		return 0, err
-	}
-	return len(data), nil
+// SendError aggregates every failed chunk of a SendLogs call.
+type SendError struct {
+	Chunks int
+	Failed []ChunkError
+
+	// handled marks the logs whose chunks were all shipped, also after the first failed chunk
+	handled []bool
 }
 
-func (s *Sentinel) chunkLogs(slice []map[string]string) ([][]map[string]string, error) {
-	var chunks [][]map[string]string
-	var currentChunk []map[string]string
-	var currentSize int
+// Handled returns which logs were shipped, so the ones in chunks after a failed chunk are not shipped twice.
+func (e *SendError) Handled() []bool {
+	return e.handled
+}
 
-	for _, logEntry := range slice {
-		// Estimate size with the new log added
-		logSize, err := s.estimateSize([]map[string]string{logEntry}) // Estimate single log size
-		if err != nil {
-			return nil, fmt.Errorf("error estimating log size: %v", err)
-		}
+func (e *SendError) Error() string {
+	failed := make([]string, len(e.Failed))
+	for i, chunkErr := range e.Failed {
+		failed[i] = fmt.Sprintf("chunk %d (%d logs): %v", chunkErr.Index+1, chunkErr.Records, chunkErr.Err)
+	}
 
-		// If adding this log exceeds maxChunkSize, save the current chunk
-		if currentSize+logSize > maxChunkSize {
-			if len(currentChunk) == 0 {
-				// Handle case where a single log is larger than the max chunk size
-				return nil, fmt.Errorf("single log exceeds max chunk size")
-			}
-			chunks = append(chunks, currentChunk)
-			currentChunk = nil
-			currentSize = 0
-		}
+	return fmt.Sprintf("%d/%d chunks failed: %s", len(e.Failed), e.Chunks, strings.Join(failed, "; "))
+}
 
-		// Add log to the current chunk
-		currentChunk = append(currentChunk, logEntry)
-		currentSize += logSize
+// chunkUploader ships a single chunk, spool optionally keeps a failed chunk for a later retry.
+type chunkUploader struct {
+	limiterKey      string
+	oversizedPolicy string
+	upload          func(ctx context.Context, chunk logChunk) error
+	spool           func(chunk logChunk, uploadErr error) error
+}
+
+// SendLogs ships the logs in parallel chunks and returns how many leading logs were fully shipped.
+// Logs after the first failed chunk are never counted, so the result can safely be used as a checkpoint.
+// The returned SendError reports every shipped log, including the ones after the first failed chunk.
+func (s *Sentinel) SendLogs(ctx context.Context, l *logrus.Logger, destination Destination, logs []map[string]string) (int, error) {
+	return s.sendLogs(ctx, l, destination, logs, s.options.OversizedPolicy)
+}
+
+// sendLogs is SendLogs with the oversized policy to apply, so replayed logs are not diverted again.
+func (s *Sentinel) sendLogs(ctx context.Context, l *logrus.Logger, destination Destination, logs []map[string]string, oversizedPolicy string) (int, error) {
+	endpoint, ruleID, streamName := destination.Endpoint, destination.RuleID, destination.StreamName
+	compressed := !s.options.DisableCompression
+
+	uploader := chunkUploader{
+		limiterKey:      ruleID,
+		oversizedPolicy: oversizedPolicy,
+		upload: func(ctx context.Context, chunk logChunk) error {
+			return s.IngestLog(ctx, endpoint, ruleID, streamName, chunk.payload, chunk.records, compressed)
+		},
 	}
 
-	// Append any remaining logs
-	if len(currentChunk) > 0 {
-		chunks = append(chunks, currentChunk)
+	if s.options.DeadLetter != nil {
+		uploader.spool = func(chunk logChunk, uploadErr error) error {
+			return s.spoolChunk(endpoint, ruleID, streamName, chunk, uploadErr)
+		}
 	}
 
-	return chunks, nil
+	return s.sendChunked(ctx, l, streamName, logs, compressed, uploader)
 }
 
-func (s *Sentinel) SendLogs(ctx context.Context, l *logrus.Logger, endpoint, ruleID, streamName string, logs []map[string]string) error {
+// sendChunked chunks the logs and uploads the chunks with a pool of workers, see SendLogs.
+func (s *Sentinel) sendChunked(ctx context.Context, l *logrus.Logger, streamName string, logs []map[string]string, compress bool, uploader chunkUploader) (int, error) {
 	logger := l.WithField("module", "sentinel_logs")
 
 	logger.WithField("stream_name", streamName).WithField("total", len(logs)).Info("chunking logs")
 
-	chunkedLogs, err := s.chunkLogs(logs)
+	chunkedLogs, err := s.chunkLogs(streamName, logs, compress, uploader.oversizedPolicy)
 	if err != nil {
-		return fmt.Errorf("failed to chunk logs: %v", err)
+		return 0, fmt.Errorf("failed to chunk logs: %v", err)
+	}
+
+	logger.WithField("chunked_logs", len(chunkedLogs)).WithField("concurrency", s.options.Concurrency).
+		Info("sending chunked logs")
+
+	limiter := s.limiter(uploader.limiterKey)
+	chunkErrs := make([]error, len(chunkedLogs))
+
+	var wg sync.WaitGroup
+	chunkIndexes := make(chan int)
+
+	for range s.options.Concurrency {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			for i := range chunkIndexes {
+				logsChunk := chunkedLogs[i]
+
+				l.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(chunkedLogs))).Debug("ingesting log chunks")
+
+				if logsChunk.records == 0 {
+					l.Debug("skipping chunk without rows")
+					continue
+				}
+
+				if err := limiter.Wait(ctx); err != nil {
+					chunkErrs[i] = fmt.Errorf("rate limit wait aborted: %v", err)
+					continue
+				}
+
+				if err := uploader.upload(ctx, logsChunk); err != nil {
+					chunkErrs[i] = fmt.Errorf("could not ingest log: %w", err)
+				}
+			}
+		}()
+	}
+
+	for i := range chunkedLogs {
+		chunkIndexes <- i
+	}
+
+	close(chunkIndexes)
+	wg.Wait()
+
+	//
+
+	sendErr := SendError{Chunks: len(chunkedLogs), handled: make([]bool, len(logs))}
+	for i := range sendErr.handled {
+		sendErr.handled[i] = true
 	}
 
-	logger.WithField("chunked_logs", len(chunkedLogs)).Info("sending chunked logs")
+	spooled := 0
+
+	for i, chunkErr := range chunkErrs {
+		if chunkErr != nil && uploader.spool != nil {
+			// a chunk that is safely spooled to disk counts as handled, it is retried on a later run
+			if spoolErr := uploader.spool(chunkedLogs[i], chunkErr); spoolErr != nil {
+				chunkErr = fmt.Errorf("%v, and could not spool it: %v", chunkErr, spoolErr)
+			} else {
+				chunkErr = nil
+				spooled++
+			}
+		}
 
-	for i, logsChunk := range chunkedLogs {
-		l.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(chunkedLogs))).Debug("ingesting log chunks")
+		if chunkErr != nil {
+			sendErr.Failed = append(sendErr.Failed, ChunkError{Index: i, Records: chunkedLogs[i].records, Err: chunkErr})
 
-		if len(logsChunk) == 0 {
-			l.Warn("processing empty chunk")
-			continue
+			// a log split over several chunks is only handled once all of them were shipped
+			for source := chunkedLogs[i].firstSource; source <= chunkedLogs[i].lastSource; source++ {
+				sendErr.handled[source] = false
+			}
 		}
+	}
 
-		if err := s.IngestLog(ctx, endpoint, ruleID, streamName, logsChunk); err != nil {
-			return fmt.Errorf("could not ingest log: %v", err)
+	shipped := len(logs)
+	for i, handled := range sendErr.handled {
+		if !handled {
+			shipped = i
+			break
 		}
 	}
 
-	//
+	if len(sendErr.Failed) > 0 {
+		logger.WithField("stream_name", streamName).WithField("failed_chunks", len(sendErr.Failed)).
+			WithField("shipped", shipped).Error("could not ship all logs")
+		return shipped, &sendErr
+	}
+
+	if spooled > 0 {
+		logger.WithField("stream_name", streamName).WithField("spooled_chunks", spooled).
+			Warn("spooled failed chunks to the dead-letter store")
+	}
 
 	logger.WithField("stream_name", streamName).Info("shipped logs")
 
-	return nil
+	return shipped, nil
 }
@@ -0,0 +1,298 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"sort"
+	"unicode/utf8"
+)
+
+const (
+	// OversizedTruncate cuts the largest fields of a record until it fits, appending a marker.
+	OversizedTruncate = "truncate"
+	// OversizedSplit moves the largest fields into continuation rows that share the EventId.
+	OversizedSplit = "split"
+	// OversizedDeadLetter diverts the record to the dead-letter store.
+	OversizedDeadLetter = "deadletter"
+
+	truncatedMarker = "...[truncated]"
+
+	// partOverhead is reserved for the Part and Parts columns of split records
+	partOverhead = 32
+
+	// maxContextSize is the largest context value that is copied onto continuation rows
+	maxContextSize = 256
+)
+
+var (
+	errRecordTooLarge = errors.New("single log exceeds max record size")
+
+	// identityFields are never truncated and are copied onto every continuation row
+	identityFields = map[string]struct{}{
+		"TimeGenerated": {},
+		"EventId":       {},
+	}
+
+	// contextFields are small columns copied onto continuation rows too, so every part can be filtered on them
+	contextFields = []string{"Action", "ActionType", "Origin", "NodeID", "TrafficType", "Protocol", "Src", "Dst"}
+)
+
+// isDynamic returns whether a field is stored in a dynamic column, its value has to stay valid JSON.
+func isDynamic(field string) bool {
+	for _, schema := range Schemas() {
+		if column, ok := schema.Column(field); ok && column.Type == insights.ColumnTypeEnumDynamic {
+			return true
+		}
+	}
+
+	return false
+}
+
+// truncatedStub is the valid JSON that replaces a dynamic value which is too large to ship.
+func truncatedStub(value string) string {
+	return fmt.Sprintf(`{"truncated":true,"original_size":%d}`, len(value))
+}
+
+func recordSize(record map[string]string) (int, error) {
+	b, err := json.Marshal(&record)
+	if err != nil {
+		return 0, fmt.Errorf("could not json encode log: %v", err)
+	}
+
+	return len(b), nil
+}
+
+func encodedLen(value string) int {
+	b, _ := json.Marshal(value)
+	return len(b)
+}
+
+// fieldsBySize returns the non-identity fields of a record, largest first.
+func fieldsBySize(record map[string]string) []string {
+	var fields []string
+	for field := range record {
+		if _, ok := identityFields[field]; !ok {
+			fields = append(fields, field)
+		}
+	}
+
+	sort.Slice(fields, func(i, j int) bool {
+		if len(record[fields[i]]) != len(record[fields[j]]) {
+			return len(record[fields[i]]) > len(record[fields[j]])
+		}
+		return fields[i] < fields[j]
+	})
+
+	return fields
+}
+
+// cutUTF8 returns the longest prefix of value of at most n bytes that does not split a rune.
+func cutUTF8(value string, n int) string {
+	if n <= 0 {
+		return ""
+	}
+	if n >= len(value) {
+		return value
+	}
+
+	for n > 0 && !utf8.RuneStart(value[n]) {
+		n--
+	}
+
+	return value[:n]
+}
+
+// truncateRecord shortens the largest fields of a record until it encodes within maxSize bytes.
+func truncateRecord(record map[string]string, maxSize int) (map[string]string, error) {
+	truncated := make(map[string]string, len(record))
+	for field, value := range record {
+		truncated[field] = value
+	}
+
+	for range 4 * len(record) {
+		size, err := recordSize(truncated)
+		if err != nil {
+			return nil, err
+		}
+
+		if size <= maxSize {
+			return truncated, nil
+		}
+
+		for _, field := range fieldsBySize(truncated) {
+			value := truncated[field]
+
+			// cutting a dynamic value would leave invalid JSON, so it is replaced as a whole
+			if isDynamic(field) {
+				stub := truncatedStub(value)
+				if len(value) <= len(stub) {
+					continue
+				}
+
+				truncated[field] = stub
+				break
+			}
+
+			if len(value) <= len(truncatedMarker) {
+				continue
+			}
+
+			// escaping can make the encoded value larger than the raw one, the next iteration cuts further if needed
+			keep := len(value) - (size - maxSize) - len(truncatedMarker)
+			truncated[field] = cutUTF8(value, keep) + truncatedMarker
+
+			break
+		}
+	}
+
+	return nil, fmt.Errorf("could not truncate log under %d bytes", maxSize)
+}
+
+// dynamicPiece stores a piece of a dynamic value as a JSON string, since the piece itself is not valid JSON.
+func dynamicPiece(piece string) string {
+	b, _ := json.Marshal(piece)
+	return string(b)
+}
+
+// splitValue cuts a value into pieces that each encode within maxSize bytes.
+// Pieces of a dynamic value are JSON strings, concatenating their decoded values restores the original.
+func splitValue(value string, maxSize int, dynamic bool) []string {
+	wrap := func(piece string) string { return piece }
+	if dynamic {
+		wrap = dynamicPiece
+	}
+
+	var pieces []string
+
+	for len(value) > 0 {
+		piece := cutUTF8(value, maxSize)
+		for piece != "" && encodedLen(wrap(piece)) > maxSize {
+			piece = cutUTF8(piece, len(piece)-max(1, (encodedLen(wrap(piece))-maxSize)/2))
+		}
+
+		if piece == "" {
+			// a single rune that can not be cut, should not happen for sane limits
+			_, size := utf8.DecodeRuneInString(value)
+			piece = value[:size]
+		}
+
+		pieces = append(pieces, wrap(piece))
+		value = value[len(piece):]
+	}
+
+	return pieces
+}
+
+// splitRecord moves the largest fields of a record into continuation rows until every row fits in maxSize bytes.
+// All rows share the identity and small context fields and are numbered with the Part and Parts columns.
+func splitRecord(record map[string]string, maxSize int) ([]map[string]string, error) {
+	main := make(map[string]string, len(record))
+	for field, value := range record {
+		main[field] = value
+	}
+
+	var moved []string
+
+	for {
+		size, err := recordSize(main)
+		if err != nil {
+			return nil, err
+		}
+
+		if size+partOverhead <= maxSize {
+			break
+		}
+
+		fields := fieldsBySize(main)
+		if len(fields) == 0 {
+			return nil, fmt.Errorf("identity fields alone exceed %d bytes", maxSize)
+		}
+
+		moved = append(moved, fields[0])
+		delete(main, fields[0])
+	}
+
+	base := make(map[string]string, len(identityFields)+len(contextFields))
+	for field := range identityFields {
+		if value, ok := record[field]; ok {
+			base[field] = value
+		}
+	}
+
+	// context fields that were moved themselves only live in their own continuation rows
+	for _, field := range contextFields {
+		if value, ok := main[field]; ok && len(value) <= maxContextSize {
+			base[field] = value
+		}
+	}
+
+	baseSize, err := recordSize(base)
+	if err != nil {
+		return nil, err
+	}
+
+	rows := []map[string]string{main}
+
+	for _, field := range moved {
+		// leave room for the field name, its quotes, colon and separating comma
+		pieceSize := maxSize - baseSize - partOverhead - encodedLen(field) - 2
+		if pieceSize <= 0 {
+			return nil, fmt.Errorf("no room to split field '%s' under %d bytes", field, maxSize)
+		}
+
+		for _, piece := range splitValue(record[field], pieceSize, isDynamic(field)) {
+			row := make(map[string]string, len(base)+3)
+			for baseField, value := range base {
+				row[baseField] = value
+			}
+
+			row[field] = piece
+			rows = append(rows, row)
+		}
+	}
+
+	for i, row := range rows {
+		row["Part"] = fmt.Sprintf("%d", i+1)
+		row["Parts"] = fmt.Sprintf("%d", len(rows))
+	}
+
+	return rows, nil
+}
+
+// handleOversized applies the oversized policy to a record, returning the rows to ship instead.
+// No rows are returned when the record was diverted to the dead-letter store.
+func (s *Sentinel) handleOversized(policy, streamName string, record map[string]string) ([]map[string]string, error) {
+	logger := s.logger.WithField("module", "sentinel_logs").WithField("stream_name", streamName).
+		WithField("event_id", record["EventId"]).WithField("policy", policy)
+
+	logger.Warn("log exceeds max record size")
+
+	switch policy {
+	case OversizedTruncate:
+		truncated, err := truncateRecord(record, maxPolicyRecordSize)
+		if err != nil {
+			return nil, err
+		}
+
+		return []map[string]string{truncated}, nil
+
+	case OversizedSplit:
+		return splitRecord(record, maxPolicyRecordSize)
+
+	case OversizedDeadLetter:
+		if s.options.DeadLetter == nil {
+			return nil, fmt.Errorf("no dead-letter store configured")
+		}
+
+		if err := s.options.DeadLetter.WriteRecords(streamName, errRecordTooLarge.Error(), []map[string]string{record}); err != nil {
+			return nil, fmt.Errorf("could not dead-letter log: %v", err)
+		}
+
+		return nil, nil
+
+	default:
+		return nil, errRecordTooLarge
+	}
+}
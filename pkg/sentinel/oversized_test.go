@@ -0,0 +1,202 @@
+package sentinel
+
+import (
+	"encoding/json"
+	"github.com/hazcod/tail2sen/pkg/deadletter"
+	"github.com/sirupsen/logrus"
+	"io"
+	"strings"
+	"testing"
+)
+
+func newTestSentinel(t *testing.T, store DeadLetter) *Sentinel {
+	t.Helper()
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return &Sentinel{logger: logger, options: UploadOptions{DeadLetter: store}}
+}
+
+// oversizedAuditRecord returns an audit record with a dynamic column over the max record size.
+func oversizedAuditRecord() map[string]string {
+	var changes []map[string]string
+	for range 20000 {
+		changes = append(changes, map[string]string{"path": "acls", "value": `tag:prod "quoted" ü`})
+	}
+
+	changesJSON, _ := json.Marshal(changes)
+
+	return map[string]string{
+		"TimeGenerated": "2024-05-01T12:00:00Z",
+		"EventId":       "event-0",
+		"Action":        "UPDATE",
+		"Old":           `{"acls":[]}`,
+		"Changes":       string(changesJSON),
+	}
+}
+
+// assertValidRows checks every row fits in a chunk and keeps its dynamic columns valid JSON.
+func assertValidRows(t *testing.T, rows []map[string]string) {
+	t.Helper()
+
+	for i, row := range rows {
+		size, err := recordSize(row)
+		if err != nil {
+			t.Fatalf("could not encode row %d: %v", i, err)
+		}
+
+		if size > maxRecordSize {
+			t.Errorf("row %d is %d bytes, over the max of %d", i, size, maxRecordSize)
+		}
+
+		for field, value := range row {
+			if isDynamic(field) && !json.Valid([]byte(value)) {
+				t.Errorf("row %d holds invalid JSON in dynamic column %s", i, field)
+			}
+		}
+
+		if row["EventId"] != "event-0" || row["TimeGenerated"] == "" {
+			t.Errorf("row %d lost its identity fields", i)
+		}
+	}
+}
+
+func TestHandleOversizedDynamicColumns(t *testing.T) {
+	tests := []struct {
+		policy string
+		check  func(t *testing.T, record map[string]string, rows []map[string]string, store *deadletter.Store)
+	}{
+		{
+			policy: OversizedTruncate,
+			check: func(t *testing.T, record map[string]string, rows []map[string]string, _ *deadletter.Store) {
+				if len(rows) != 1 {
+					t.Fatalf("expected a single truncated row, got %d", len(rows))
+				}
+
+				if rows[0]["Changes"] != truncatedStub(record["Changes"]) {
+					t.Fatalf("expected the dynamic column to be replaced, got %.100s", rows[0]["Changes"])
+				}
+
+				if rows[0]["Old"] != record["Old"] {
+					t.Fatal("a small dynamic column was truncated")
+				}
+			},
+		},
+		{
+			policy: OversizedSplit,
+			check: func(t *testing.T, record map[string]string, rows []map[string]string, _ *deadletter.Store) {
+				if len(rows) < 3 {
+					t.Fatalf("expected the dynamic column to be split over several rows, got %d", len(rows))
+				}
+
+				var changes strings.Builder
+				for i, row := range rows {
+					if row["Part"] == "" || row["Parts"] == "" {
+						t.Errorf("row %d is not numbered", i)
+					}
+
+					value, ok := row["Changes"]
+					if !ok {
+						continue
+					}
+
+					var piece string
+					if err := json.Unmarshal([]byte(value), &piece); err != nil {
+						t.Fatalf("row %d holds a piece that is not a JSON string: %v", i, err)
+					}
+
+					changes.WriteString(piece)
+				}
+
+				if changes.String() != record["Changes"] {
+					t.Fatal("the pieces do not restore the original value")
+				}
+			},
+		},
+		{
+			policy: OversizedDeadLetter,
+			check: func(t *testing.T, record map[string]string, rows []map[string]string, store *deadletter.Store) {
+				if len(rows) != 0 {
+					t.Fatalf("expected the record to be diverted, got %d rows", len(rows))
+				}
+
+				recordSets, err := store.RecordSets()
+				if err != nil {
+					t.Fatalf("could not list record sets: %v", err)
+				}
+
+				if len(recordSets) != 1 || len(recordSets[0].Records) != 1 {
+					t.Fatalf("expected the record in the dead-letter store, got %v", recordSets)
+				}
+
+				if recordSets[0].Records[0]["Changes"] != record["Changes"] {
+					t.Fatal("the dead-letter store changed the record")
+				}
+
+				// replaying diverted records splits them, which has to keep the dynamic column valid too
+				replayed, err := splitRecord(recordSets[0].Records[0], maxPolicyRecordSize)
+				if err != nil {
+					t.Fatalf("could not split the replayed record: %v", err)
+				}
+
+				assertValidRows(t, replayed)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.policy, func(t *testing.T) {
+			logger := logrus.New()
+			logger.SetOutput(io.Discard)
+
+			store, err := deadletter.New(logger, t.TempDir())
+			if err != nil {
+				t.Fatalf("could not create dead-letter store: %v", err)
+			}
+
+			record := oversizedAuditRecord()
+			if size, _ := recordSize(record); size <= maxRecordSize {
+				t.Fatalf("record of %d bytes is not oversized", size)
+			}
+
+			rows, err := newTestSentinel(t, store).handleOversized(test.policy, AuditSchema.StreamName(), record)
+			if err != nil {
+				t.Fatalf("could not handle oversized record: %v", err)
+			}
+
+			assertValidRows(t, rows)
+			test.check(t, record, rows, store)
+		})
+	}
+}
+
+func TestSplitValue(t *testing.T) {
+	value := strings.Repeat(`a"\ü`, 100)
+
+	for _, dynamic := range []bool{false, true} {
+		pieces := splitValue(value, 50, dynamic)
+
+		var restored strings.Builder
+		for _, piece := range pieces {
+			if encodedLen(piece) > 50 {
+				t.Errorf("piece of %d encoded bytes is over the max of 50", encodedLen(piece))
+			}
+
+			if dynamic {
+				var decoded string
+				if err := json.Unmarshal([]byte(piece), &decoded); err != nil {
+					t.Fatalf("dynamic piece is not a JSON string: %v", err)
+				}
+
+				piece = decoded
+			}
+
+			restored.WriteString(piece)
+		}
+
+		if restored.String() != value {
+			t.Errorf("dynamic %v: pieces do not restore the value", dynamic)
+		}
+	}
+}
@@ -0,0 +1,171 @@
+package sentinel
+
+import (
+	"context"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	"github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/monitor/armmonitor"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	workspaceDestinationName = "workspace"
+)
+
+var (
+	// kqlConversions cast the string values of the input stream to the table column types
+	kqlConversions = map[insights.ColumnTypeEnum]string{
+		insights.ColumnTypeEnumInt:      "toint",
+		insights.ColumnTypeEnumLong:     "tolong",
+		insights.ColumnTypeEnumReal:     "toreal",
+		insights.ColumnTypeEnumBoolean:  "tobool",
+		insights.ColumnTypeEnumDateTime: "todatetime",
+		insights.ColumnTypeEnumDynamic:  "todynamic",
+	}
+)
+
+// ProvisionedEndpoint is a data collection endpoint logs can be uploaded to.
+type ProvisionedEndpoint struct {
+	ID             string
+	IngestEndpoint string
+}
+
+// StreamName is the custom stream a data collection rule declares for the schema.
+func (s *Schema) StreamName() string {
+	return "Custom-" + s.TableName
+}
+
+// streamDeclaration declares every column as string since converted logs only carry string values,
+// the transform of the data collection rule casts them to the table column types.
+func (s *Schema) streamDeclaration() *armmonitor.StreamDeclaration {
+	columns := make([]*armmonitor.ColumnDefinition, len(s.Columns))
+	for i, column := range s.Columns {
+		columns[i] = &armmonitor.ColumnDefinition{
+			Name: to.Ptr(column.Name),
+			Type: to.Ptr(armmonitor.KnownColumnDefinitionTypeString),
+		}
+	}
+
+	return &armmonitor.StreamDeclaration{Columns: columns}
+}
+
+// transformKQL returns the transformation that casts the input stream to the table column types.
+func (s *Schema) transformKQL() string {
+	var casts []string
+
+	for _, column := range s.Columns {
+		if conversion, ok := kqlConversions[column.Type]; ok {
+			casts = append(casts, fmt.Sprintf("%s = %s(%s)", column.Name, conversion, column.Name))
+		}
+	}
+
+	if len(casts) == 0 {
+		return "source"
+	}
+
+	return "source | extend " + strings.Join(casts, ", ")
+}
+
+func workspaceResourceID(subscriptionID string, workspace Workspace) string {
+	return fmt.Sprintf("/subscriptions/%s/resourceGroups/%s/providers/Microsoft.OperationalInsights/workspaces/%s",
+		subscriptionID, workspace.ResourceGroup, workspace.Name)
+}
+
+// ProvisionEndpoint creates or updates a data collection endpoint.
+func (s *Sentinel) ProvisionEndpoint(ctx context.Context, l *logrus.Logger, resourceGroup, name, location string) (ProvisionedEndpoint, error) {
+	logger := l.WithField("module", "sentinel_provision")
+
+	endpointsClient, err := armmonitor.NewDataCollectionEndpointsClient(s.creds.SubscriptionID, s.azCreds, s.armOptions())
+	if err != nil {
+		return ProvisionedEndpoint{}, fmt.Errorf("could not create data collection endpoint client: %v", err)
+	}
+
+	logger.WithField("endpoint_name", name).Info("creating or updating data collection endpoint")
+
+	resp, err := endpointsClient.Create(ctx, resourceGroup, name, &armmonitor.DataCollectionEndpointsClientCreateOptions{
+		Body: &armmonitor.DataCollectionEndpointResource{
+			Location: to.Ptr(location),
+			Properties: &armmonitor.DataCollectionEndpointResourceProperties{
+				Description: to.Ptr("Endpoint used by tail2sen to ingest tailscale logs."),
+				NetworkACLs: &armmonitor.DataCollectionEndpointNetworkACLs{
+					PublicNetworkAccess: to.Ptr(armmonitor.KnownPublicNetworkAccessOptionsEnabled),
+				},
+			},
+		},
+	})
+	if err != nil {
+		return ProvisionedEndpoint{}, fmt.Errorf("could not create data collection endpoint '%s': %v", name, err)
+	}
+
+	if resp.ID == nil || resp.Properties == nil || resp.Properties.LogsIngestion == nil || resp.Properties.LogsIngestion.Endpoint == nil {
+		return ProvisionedEndpoint{}, fmt.Errorf("data collection endpoint '%s' has no logs ingestion endpoint", name)
+	}
+
+	logger.WithField("endpoint_name", name).Info("created data collection endpoint")
+
+	return ProvisionedEndpoint{
+		ID:             *resp.ID,
+		IngestEndpoint: *resp.Properties.LogsIngestion.Endpoint,
+	}, nil
+}
+
+// ProvisionRule creates or updates a data collection rule that routes the stream of a schema into its table.
+// The table must exist already, the returned destination can be used to upload logs.
+func (s *Sentinel) ProvisionRule(ctx context.Context, l *logrus.Logger, workspace Workspace, name, location string, endpoint ProvisionedEndpoint, schema *Schema) (Destination, error) {
+	logger := l.WithField("module", "sentinel_provision")
+
+	rulesClient, err := armmonitor.NewDataCollectionRulesClient(s.creds.SubscriptionID, s.azCreds, s.armOptions())
+	if err != nil {
+		return Destination{}, fmt.Errorf("could not create data collection rule client: %v", err)
+	}
+
+	streamName := schema.StreamName()
+
+	logger.WithField("rule_name", name).WithField("stream_name", streamName).Info("creating or updating data collection rule")
+
+	resp, err := rulesClient.Create(ctx, workspace.ResourceGroup, name, &armmonitor.DataCollectionRulesClientCreateOptions{
+		Body: &armmonitor.DataCollectionRuleResource{
+			Location: to.Ptr(location),
+			Properties: &armmonitor.DataCollectionRuleResourceProperties{
+				Description:              to.Ptr(fmt.Sprintf("Routes tail2sen logs into %s.", schema.TableName)),
+				DataCollectionEndpointID: to.Ptr(endpoint.ID),
+				StreamDeclarations: map[string]*armmonitor.StreamDeclaration{
+					streamName: schema.streamDeclaration(),
+				},
+				Destinations: &armmonitor.DataCollectionRuleDestinations{
+					LogAnalytics: []*armmonitor.LogAnalyticsDestination{
+						{
+							Name:                to.Ptr(workspaceDestinationName),
+							WorkspaceResourceID: to.Ptr(workspaceResourceID(s.creds.SubscriptionID, workspace)),
+						},
+					},
+				},
+				DataFlows: []*armmonitor.DataFlow{
+					{
+						Streams:      []*armmonitor.KnownDataFlowStreams{to.Ptr(armmonitor.KnownDataFlowStreams(streamName))},
+						Destinations: []*string{to.Ptr(workspaceDestinationName)},
+						TransformKql: to.Ptr(schema.transformKQL()),
+						OutputStream: to.Ptr(streamName),
+					},
+				},
+			},
+		},
+	})
+	if err != nil {
+		return Destination{}, fmt.Errorf("could not create data collection rule '%s': %v", name, err)
+	}
+
+	if resp.Properties == nil || resp.Properties.ImmutableID == nil {
+		return Destination{}, fmt.Errorf("data collection rule '%s' has no immutable id", name)
+	}
+
+	logger.WithField("rule_name", name).Info("created data collection rule")
+
+	return Destination{
+		Endpoint:   endpoint.IngestEndpoint,
+		RuleID:     *resp.Properties.ImmutableID,
+		StreamName: streamName,
+	}, nil
+}
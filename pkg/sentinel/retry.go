@@ -0,0 +1,216 @@
+package sentinel
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/hazcod/tail2sen/pkg/deadletter"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"net/http"
+	"time"
+)
+
+// retryable returns whether an upload can succeed when retried, client errors other than throttling and timeouts never do.
+func retryable(uploadErr error) bool {
+	var respErr *azcore.ResponseError
+	if !errors.As(uploadErr, &respErr) {
+		return true
+	}
+
+	switch respErr.StatusCode {
+	case http.StatusRequestTimeout, http.StatusTooManyRequests:
+		return true
+	}
+
+	return respErr.StatusCode < 400 || respErr.StatusCode > 499
+}
+
+// spoolChunk stores a chunk that failed to upload in the dead-letter store.
+// A chunk that was rejected is moved aside right away instead of being retried.
+func (s *Sentinel) spoolChunk(endpoint, ruleID, streamName string, chunk logChunk, uploadErr error) error {
+	now := time.Now().UTC()
+
+	deadChunk := &deadletter.Chunk{
+		Endpoint:    endpoint,
+		RuleID:      ruleID,
+		StreamName:  streamName,
+		Compressed:  !s.options.DisableCompression,
+		Records:     chunk.records,
+		Attempts:    1,
+		LastError:   uploadErr.Error(),
+		Created:     now,
+		NextAttempt: now.Add(deadletter.Backoff(1)),
+		Payload:     chunk.payload,
+	}
+
+	if !retryable(uploadErr) {
+		return s.options.DeadLetter.FailChunk(deadChunk)
+	}
+
+	return s.options.DeadLetter.WriteChunk(deadChunk)
+}
+
+// RetryDeadLetters uploads the chunks in the dead-letter store whose backoff expired, or all of them when forced.
+// Delivered chunks are removed, failed ones are rescheduled with an increased backoff.
+// Chunks that were rejected or reached the max attempts are moved aside and no longer retried.
+func (s *Sentinel) RetryDeadLetters(ctx context.Context, l *logrus.Logger, force bool) error {
+	logger := l.WithField("module", "sentinel_retry")
+
+	if s.options.DeadLetter == nil {
+		return fmt.Errorf("no dead-letter store configured")
+	}
+
+	chunks, err := s.options.DeadLetter.Chunks()
+	if err != nil {
+		return err
+	}
+
+	logger.WithField("total", len(chunks)).WithField("force", force).Info("retrying dead-letter chunks")
+
+	delivered, failed, gaveUp := 0, 0, 0
+
+	for _, chunk := range chunks {
+		now := time.Now().UTC()
+
+		if !force && now.Before(chunk.NextAttempt) {
+			logger.WithField("stream_name", chunk.StreamName).WithField("next_attempt", chunk.NextAttempt).
+				Debug("skipping dead-letter chunk in backoff")
+			continue
+		}
+
+		if err := s.limiter(chunk.RuleID).Wait(ctx); err != nil {
+			return fmt.Errorf("rate limit wait aborted: %v", err)
+		}
+
+		uploadErr := s.IngestLog(ctx, chunk.Endpoint, chunk.RuleID, chunk.StreamName, chunk.Payload, chunk.Records, chunk.Compressed)
+		if uploadErr == nil {
+			if err := s.options.DeadLetter.RemoveChunk(chunk); err != nil {
+				return err
+			}
+
+			delivered++
+			continue
+		}
+
+		chunk.Attempts++
+		chunk.LastError = uploadErr.Error()
+
+		if !retryable(uploadErr) || chunk.Attempts >= s.options.MaxAttempts {
+			logger.WithError(uploadErr).WithField("stream_name", chunk.StreamName).
+				WithField("attempts", chunk.Attempts).Error("giving up on dead-letter chunk")
+
+			if err := s.options.DeadLetter.FailChunk(chunk); err != nil {
+				return err
+			}
+
+			gaveUp++
+			continue
+		}
+
+		failed++
+		chunk.NextAttempt = now.Add(deadletter.Backoff(chunk.Attempts))
+
+		logger.WithError(uploadErr).WithField("stream_name", chunk.StreamName).
+			WithField("attempts", chunk.Attempts).WithField("next_attempt", chunk.NextAttempt).
+			Warn("could not deliver dead-letter chunk")
+
+		if err := s.options.DeadLetter.WriteChunk(chunk); err != nil {
+			return err
+		}
+	}
+
+	logger.WithField("delivered", delivered).WithField("failed", failed).WithField("gave_up", gaveUp).
+		Info("retried dead-letter chunks")
+
+	if gaveUp > 0 {
+		return fmt.Errorf("%d dead-letter chunks were moved aside after failing for good", gaveUp)
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d dead-letter chunks could not be delivered", failed)
+	}
+
+	return nil
+}
+
+// ReplayRecords ships the records diverted to the dead-letter store by the dead-letter oversized policy.
+// Oversized records are split instead, unless truncate is configured, so they are not diverted again.
+// Record sets are removed once shipped, sets of streams without a destination are left in place.
+func (s *Sentinel) ReplayRecords(ctx context.Context, l *logrus.Logger, destinations map[sink.Stream]StreamDestination) error {
+	logger := l.WithField("module", "sentinel_retry")
+
+	if s.options.DeadLetter == nil {
+		return fmt.Errorf("no dead-letter store configured")
+	}
+
+	recordSets, err := s.options.DeadLetter.RecordSets()
+	if err != nil {
+		return err
+	}
+
+	oversizedPolicy := s.options.OversizedPolicy
+	if oversizedPolicy != OversizedTruncate {
+		oversizedPolicy = OversizedSplit
+	}
+
+	logger.WithField("total", len(recordSets)).WithField("policy", oversizedPolicy).Info("replaying dead-letter records")
+
+	delivered, failed := 0, 0
+
+	for _, recordSet := range recordSets {
+		recordLogger := logger.WithField("stream_name", recordSet.StreamName).WithField("records", len(recordSet.Records))
+
+		destination, ok := findDestination(destinations, recordSet.StreamName)
+		if !ok {
+			recordLogger.Warn("no destination configured for dead-letter records")
+			failed++
+			continue
+		}
+
+		if destination.Collector.WorkspaceID != "" {
+			_, err = s.sendCollectorLogs(ctx, l, destination.Collector, recordSet.Records, oversizedPolicy)
+		} else {
+			_, err = s.sendLogs(ctx, l, destination.Destination, recordSet.Records, oversizedPolicy)
+		}
+
+		if err != nil {
+			recordLogger.WithError(err).Warn("could not replay dead-letter records")
+			failed++
+			continue
+		}
+
+		if err := s.options.DeadLetter.RemoveRecordSet(recordSet); err != nil {
+			return err
+		}
+
+		delivered++
+	}
+
+	logger.WithField("delivered", delivered).WithField("failed", failed).Info("replayed dead-letter records")
+
+	if failed > 0 {
+		return fmt.Errorf("%d dead-letter record sets could not be replayed", failed)
+	}
+
+	return nil
+}
+
+// findDestination returns the destination a stream was shipped to, by its stream name or collector log type.
+func findDestination(destinations map[sink.Stream]StreamDestination, streamName string) (StreamDestination, bool) {
+	for _, destination := range destinations {
+		if destination.Collector.WorkspaceID != "" {
+			if destination.Collector.LogType == streamName {
+				return destination, true
+			}
+			continue
+		}
+
+		if destination.Destination.StreamName == streamName {
+			return destination, true
+		}
+	}
+
+	return StreamDestination{}, false
+}
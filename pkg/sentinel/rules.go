@@ -0,0 +1,180 @@
+package sentinel
+
+import (
+	"context"
+	"embed"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	security "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+	"path"
+	"sort"
+)
+
+const (
+	// RuleSourceAudit rules query the audit table and are deployed to its workspace.
+	RuleSourceAudit = "audit"
+	// RuleSourceNetwork rules query the network table and are deployed to its workspace.
+	RuleSourceNetwork = "network"
+)
+
+var (
+	//go:embed rules/*.yaml
+	ruleFiles embed.FS
+
+	// contentNamespace derives stable resource ids from template names, so deploying twice updates the same resources
+	contentNamespace = uuid.NewSHA1(uuid.NameSpaceURL, []byte("https://github.com/hazcod/tail2sen/rules"))
+)
+
+// EntityMapping maps a column of the query results to a Sentinel entity.
+type EntityMapping struct {
+	EntityType    string         `yaml:"entityType" json:"entityType"`
+	FieldMappings []FieldMapping `yaml:"fieldMappings" json:"fieldMappings"`
+}
+
+type FieldMapping struct {
+	Identifier string `yaml:"identifier" json:"identifier"`
+	ColumnName string `yaml:"columnName" json:"columnName"`
+}
+
+// RuleTemplate is a scheduled analytics rule bundled with tail2sen.
+type RuleTemplate struct {
+	Name             string          `yaml:"name"`
+	Source           string          `yaml:"source"`
+	DisplayName      string          `yaml:"displayName"`
+	Description      string          `yaml:"description"`
+	Severity         string          `yaml:"severity"`
+	QueryFrequency   string          `yaml:"queryFrequency"`
+	QueryPeriod      string          `yaml:"queryPeriod"`
+	TriggerOperator  string          `yaml:"triggerOperator"`
+	TriggerThreshold int             `yaml:"triggerThreshold"`
+	Tactics          []string        `yaml:"tactics"`
+	EntityMappings   []EntityMapping `yaml:"entityMappings"`
+	// Query is a text/template rendered with TemplateParameters.
+	Query string `yaml:"query"`
+}
+
+// RuleTemplates returns the bundled analytics rule templates, sorted by name.
+func RuleTemplates() ([]RuleTemplate, error) {
+	paths, err := ruleFiles.ReadDir("rules")
+	if err != nil {
+		return nil, fmt.Errorf("could not list rule templates: %v", err)
+	}
+
+	names := make(map[string]struct{}, len(paths))
+	templates := make([]RuleTemplate, 0, len(paths))
+
+	for _, entry := range paths {
+		fileBytes, err := ruleFiles.ReadFile(path.Join("rules", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("could not read rule template '%s': %v", entry.Name(), err)
+		}
+
+		var rule RuleTemplate
+		if err := yaml.Unmarshal(fileBytes, &rule); err != nil {
+			return nil, fmt.Errorf("could not decode rule template '%s': %v", entry.Name(), err)
+		}
+
+		if rule.Name == "" || rule.DisplayName == "" || rule.Query == "" {
+			return nil, fmt.Errorf("rule template '%s' misses a name, display name or query", entry.Name())
+		}
+
+		if rule.Source != RuleSourceAudit && rule.Source != RuleSourceNetwork {
+			return nil, fmt.Errorf("rule template '%s' has unknown source '%s'", entry.Name(), rule.Source)
+		}
+
+		if _, ok := names[rule.Name]; ok {
+			return nil, fmt.Errorf("duplicate rule template name '%s'", rule.Name)
+		}
+		names[rule.Name] = struct{}{}
+
+		templates = append(templates, rule)
+	}
+
+	sort.Slice(templates, func(i, j int) bool {
+		return templates[i].Name < templates[j].Name
+	})
+
+	return templates, nil
+}
+
+// ID is the stable alert rule id of the template.
+func (r *RuleTemplate) ID() string {
+	return uuid.NewSHA1(contentNamespace, []byte(r.Name)).String()
+}
+
+// RenderQuery substitutes the parameters in the query of the template.
+func (r *RuleTemplate) RenderQuery(params TemplateParameters) (string, error) {
+	query, err := renderTemplate(r.Name, r.Query, params)
+	if err != nil {
+		return "", fmt.Errorf("could not render query of rule '%s': %v", r.Name, err)
+	}
+
+	return query, nil
+}
+
+// entityMappings converts the entity mappings of a template to the ones of the alert rules API.
+func entityMappings(mappings []EntityMapping) []*security.EntityMapping {
+	converted := make([]*security.EntityMapping, 0, len(mappings))
+
+	for _, mapping := range mappings {
+		fieldMappings := make([]*security.FieldMapping, 0, len(mapping.FieldMappings))
+		for _, fieldMapping := range mapping.FieldMappings {
+			fieldMappings = append(fieldMappings, &security.FieldMapping{
+				Identifier: to.Ptr(fieldMapping.Identifier),
+				ColumnName: to.Ptr(fieldMapping.ColumnName),
+			})
+		}
+
+		converted = append(converted, &security.EntityMapping{
+			EntityType:    to.Ptr(security.EntityMappingType(mapping.EntityType)),
+			FieldMappings: fieldMappings,
+		})
+	}
+
+	return converted
+}
+
+// DeployRule creates or updates the scheduled analytics rule of a template in the Sentinel workspace.
+func (s *Sentinel) DeployRule(ctx context.Context, l *logrus.Logger, workspace Workspace, rule RuleTemplate, params TemplateParameters) error {
+	logger := l.WithField("module", "sentinel_rules").WithField("rule_name", rule.Name)
+
+	query, err := rule.RenderQuery(params)
+	if err != nil {
+		return err
+	}
+
+	tactics := make([]*security.AttackTactic, 0, len(rule.Tactics))
+	for _, tactic := range rule.Tactics {
+		tactics = append(tactics, to.Ptr(security.AttackTactic(tactic)))
+	}
+
+	logger.Info("creating or updating analytics rule")
+
+	if _, err := s.alertRulesClient.CreateOrUpdate(ctx, workspace.ResourceGroup, workspace.Name, rule.ID(), &security.ScheduledAlertRule{
+		Kind: to.Ptr(security.AlertRuleKindScheduled),
+		Properties: &security.ScheduledAlertRuleProperties{
+			DisplayName:         to.Ptr(rule.DisplayName),
+			Description:         to.Ptr(rule.Description),
+			Severity:            to.Ptr(security.AlertSeverity(rule.Severity)),
+			Enabled:             to.Ptr(true),
+			Query:               to.Ptr(query),
+			QueryFrequency:      to.Ptr(rule.QueryFrequency),
+			QueryPeriod:         to.Ptr(rule.QueryPeriod),
+			TriggerOperator:     to.Ptr(security.TriggerOperator(rule.TriggerOperator)),
+			TriggerThreshold:    to.Ptr(int32(rule.TriggerThreshold)),
+			SuppressionDuration: to.Ptr("PT5H"),
+			SuppressionEnabled:  to.Ptr(false),
+			Tactics:             tactics,
+			EntityMappings:      entityMappings(rule.EntityMappings),
+		},
+	}, nil); err != nil {
+		return fmt.Errorf("could not deploy analytics rule '%s': %v", rule.Name, err)
+	}
+
+	logger.Info("deployed analytics rule")
+
+	return nil
+}
@@ -0,0 +1,144 @@
+package sentinel
+
+import (
+	"fmt"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Column is a single column of a custom table.
+type Column struct {
+	Name string
+	Type insights.ColumnTypeEnum
+}
+
+// Schema declares the table a stream of converted logs is stored in.
+type Schema struct {
+	TableName   string
+	Description string
+	Columns     []Column
+}
+
+var (
+	// AuditSchema is the table of converted tailscale audit logs.
+	AuditSchema = Schema{
+		TableName:   "TailscaleAuditLogs_CL",
+		Description: "Table that contains tailscale audit logs ingested by tail2sen.",
+		Columns: []Column{
+			{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+			{Name: "EventId", Type: insights.ColumnTypeEnumString},
+			{Name: "Part", Type: insights.ColumnTypeEnumInt},
+			{Name: "Parts", Type: insights.ColumnTypeEnumInt},
+			{Name: "Action", Type: insights.ColumnTypeEnumString},
+			{Name: "ActionType", Type: insights.ColumnTypeEnumString},
+			{Name: "Origin", Type: insights.ColumnTypeEnumString},
+			{Name: "Actor", Type: insights.ColumnTypeEnumString},
+			{Name: "Target", Type: insights.ColumnTypeEnumString},
+			{Name: "Old", Type: insights.ColumnTypeEnumDynamic},
+			{Name: "New", Type: insights.ColumnTypeEnumDynamic},
+			{Name: "Changes", Type: insights.ColumnTypeEnumDynamic},
+		},
+	}
+
+	// NetworkSchema is the table of converted tailscale network logs.
+	NetworkSchema = Schema{
+		TableName:   "TailscaleNetworkLogs_CL",
+		Description: "Table that contains tailscale network logs ingested by tail2sen.",
+		Columns: []Column{
+			{Name: "TimeGenerated", Type: insights.ColumnTypeEnumDateTime},
+			{Name: "EventId", Type: insights.ColumnTypeEnumString},
+			{Name: "Part", Type: insights.ColumnTypeEnumInt},
+			{Name: "Parts", Type: insights.ColumnTypeEnumInt},
+			{Name: "NodeID", Type: insights.ColumnTypeEnumString},
+			{Name: "Start", Type: insights.ColumnTypeEnumDateTime},
+			{Name: "End", Type: insights.ColumnTypeEnumDateTime},
+			{Name: "Index", Type: insights.ColumnTypeEnumInt},
+			{Name: "Protocol", Type: insights.ColumnTypeEnumString},
+			{Name: "Src", Type: insights.ColumnTypeEnumString},
+			{Name: "Dst", Type: insights.ColumnTypeEnumString},
+			{Name: "Bytes", Type: insights.ColumnTypeEnumInt},
+			{Name: "Packets", Type: insights.ColumnTypeEnumInt},
+			{Name: "TrafficType", Type: insights.ColumnTypeEnumString},
+			{Name: "SrcCountry", Type: insights.ColumnTypeEnumString},
+			{Name: "SrcCity", Type: insights.ColumnTypeEnumString},
+			{Name: "SrcASN", Type: insights.ColumnTypeEnumInt},
+			{Name: "SrcASOrg", Type: insights.ColumnTypeEnumString},
+			{Name: "DstCountry", Type: insights.ColumnTypeEnumString},
+			{Name: "DstCity", Type: insights.ColumnTypeEnumString},
+			{Name: "DstASN", Type: insights.ColumnTypeEnumInt},
+			{Name: "DstASOrg", Type: insights.ColumnTypeEnumString},
+		},
+	}
+
+	schemas = []*Schema{&AuditSchema, &NetworkSchema}
+)
+
+// Schemas returns every registered table schema.
+func Schemas() []*Schema {
+	return schemas
+}
+
+// Column returns the column with the given name, if it is declared.
+func (s *Schema) Column(name string) (Column, bool) {
+	for _, column := range s.Columns {
+		if column.Name == name {
+			return column, true
+		}
+	}
+
+	return Column{}, false
+}
+
+func validateValue(columnType insights.ColumnTypeEnum, value string) error {
+	switch columnType {
+	case insights.ColumnTypeEnumDateTime:
+		if _, err := time.Parse(time.RFC3339, value); err != nil {
+			return fmt.Errorf("invalid datetime: %v", err)
+		}
+	case insights.ColumnTypeEnumInt, insights.ColumnTypeEnumLong:
+		if _, err := strconv.ParseInt(value, 10, 64); err != nil {
+			return fmt.Errorf("invalid integer: %v", err)
+		}
+	case insights.ColumnTypeEnumReal:
+		if _, err := strconv.ParseFloat(value, 64); err != nil {
+			return fmt.Errorf("invalid real: %v", err)
+		}
+	case insights.ColumnTypeEnumBoolean:
+		if _, err := strconv.ParseBool(value); err != nil {
+			return fmt.Errorf("invalid boolean: %v", err)
+		}
+	}
+
+	// strings and dynamic columns accept anything, non-JSON dynamic values are stored as a string
+	return nil
+}
+
+// Validate checks that converted logs only use declared columns with values that match the column type.
+func (s *Schema) Validate(logs []map[string]string) error {
+	for i, log := range logs {
+		fields := make([]string, 0, len(log))
+		for field := range log {
+			fields = append(fields, field)
+		}
+		sort.Strings(fields)
+
+		for _, field := range fields {
+			column, ok := s.Column(field)
+			if !ok {
+				return fmt.Errorf("log %d: column '%s' is not declared in table '%s'", i, field, s.TableName)
+			}
+
+			if err := validateValue(column.Type, log[field]); err != nil {
+				return fmt.Errorf("log %d: column '%s' of table '%s': %v", i, field, s.TableName, err)
+			}
+		}
+
+		if _, ok := log["TimeGenerated"]; !ok {
+			return fmt.Errorf("log %d: missing TimeGenerated for table '%s'", i, s.TableName)
+		}
+	}
+
+	return nil
+}
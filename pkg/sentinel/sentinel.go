@@ -2,10 +2,23 @@ package sentinel
 
 import (
 	"fmt"
-	"github.com/Azure/azure-sdk-for-go/sdk/azidentity"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/cloud"
+	"github.com/Azure/azure-sdk-for-go/sdk/monitor/ingestion/azlogs"
+	workbooks "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/applicationinsights/armapplicationinsights/v2"
+	insights "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/operationalinsights/armoperationalinsights/v2"
+	security "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/securityinsights/armsecurityinsights"
+	"github.com/hazcod/tail2sen/pkg/deadletter"
 	"github.com/hazcod/tail2sen/pkg/utils"
 	"github.com/sirupsen/logrus"
+	"golang.org/x/time/rate"
 	"net/http"
+	"sync"
+)
+
+const (
+	defaultUploadConcurrency = 4
+	defaultMaxAttempts       = 10
 )
 
 type Credentials struct {
@@ -13,32 +26,187 @@ type Credentials struct {
 	ClientID       string
 	ClientSecret   string
 	SubscriptionID string
-	ResourceGroup  string
-	WorkspaceName  string
+	// Cloud is the azure cloud to connect to, defaults to the public cloud.
+	Cloud string
+
+	// Methods are tried in order until one returns a token, defaults to the client secret.
+	Methods                 []string
+	CertificatePath         string
+	CertificatePassword     string
+	ManagedIdentityClientID string
+	FederatedTokenFile      string
+}
+
+// Workspace is the Log Analytics workspace that holds the tables.
+type Workspace struct {
+	ResourceGroup string
+	Name          string
+}
+
+// Destination is where a stream of logs is uploaded to through the Logs Ingestion API.
+type Destination struct {
+	Endpoint   string
+	RuleID     string
+	StreamName string
+}
+
+// DeadLetter stores what can not be shipped, see deadletter.Store.
+type DeadLetter interface {
+	WriteRecords(streamName, reason string, records []map[string]string) error
+	RecordSets() ([]*deadletter.RecordSet, error)
+	RemoveRecordSet(recordSet *deadletter.RecordSet) error
+
+	WriteChunk(chunk *deadletter.Chunk) error
+	FailChunk(chunk *deadletter.Chunk) error
+	Chunks() ([]*deadletter.Chunk, error)
+	RemoveChunk(chunk *deadletter.Chunk) error
+}
+
+// UploadOptions tunes how chunks are shipped to the Logs Ingestion API.
+type UploadOptions struct {
+	// Concurrency is the amount of chunks uploaded in parallel, defaults to 4.
+	Concurrency int
+	// RequestsPerSecond limits the uploads per data collection rule, zero means unlimited.
+	RequestsPerSecond float64
+	// DisableCompression uploads plain JSON instead of gzip compressed chunks.
+	DisableCompression bool
+	// OversizedPolicy decides what happens to logs that exceed the max record size, defaults to truncate.
+	OversizedPolicy string
+	// DeadLetter stores logs diverted by the dead-letter oversized policy and chunks that failed to upload.
+	DeadLetter DeadLetter
+	// MaxAttempts is the amount of uploads of a dead-letter chunk before it is moved aside, defaults to 10.
+	MaxAttempts int
 }
 
 type Sentinel struct {
-	creds  Credentials
-	logger *logrus.Logger
+	creds   Credentials
+	options UploadOptions
+	logger  *logrus.Logger
+
+	limitersLock sync.Mutex
+	limiters     map[string]*rate.Limiter
+
+	// ingestClients are shared by every upload to the same data collection endpoint
+	ingestClientsLock sync.Mutex
+	ingestClients     map[string]*azlogs.Client
+
+	cloud        cloud.Configuration
+	azCreds      azcore.TokenCredential
+	tablesClient *insights.TablesClient
+	httpClient   *http.Client
 
-	azCreds    *azidentity.ClientSecretCredential
-	httpClient *http.Client
+	// alertRulesClient, savedSearchesClient and workbooksClient deploy the bundled content
+	alertRulesClient    *security.AlertRulesClient
+	savedSearchesClient *insights.SavedSearchesClient
+	workbooksClient     *workbooks.WorkbooksClient
 }
 
-func New(logger *logrus.Logger, creds Credentials) (*Sentinel, error) {
+func New(logger *logrus.Logger, creds Credentials, options UploadOptions) (*Sentinel, error) {
+	if options.Concurrency <= 0 {
+		options.Concurrency = defaultUploadConcurrency
+	}
+
+	if options.MaxAttempts <= 0 {
+		options.MaxAttempts = defaultMaxAttempts
+	}
+
+	if options.OversizedPolicy == "" {
+		options.OversizedPolicy = OversizedTruncate
+	}
+
+	switch options.OversizedPolicy {
+	case OversizedTruncate, OversizedSplit:
+	case OversizedDeadLetter:
+		if options.DeadLetter == nil {
+			return nil, fmt.Errorf("oversized policy '%s' requires a dead-letter store", options.OversizedPolicy)
+		}
+	default:
+		return nil, fmt.Errorf("unknown oversized policy '%s'", options.OversizedPolicy)
+	}
+
 	sentinel := Sentinel{
-		creds:  creds,
-		logger: logger,
+		creds:    creds,
+		options:  options,
+		logger:   logger,
+		limiters: make(map[string]*rate.Limiter),
+
+		ingestClients: make(map[string]*azlogs.Client),
 	}
 
 	sentinel.httpClient = utils.NewLogHttpClient(logger)
 
-	azCreds, err := azidentity.NewClientSecretCredential(creds.TenantID, creds.ClientID, creds.ClientSecret, nil)
+	azCloud, err := cloudConfiguration(creds.Cloud)
+	if err != nil {
+		return nil, err
+	}
+
+	sentinel.cloud = azCloud
+
+	azCreds, err := newCredential(creds, sentinel.clientOptions())
 	if err != nil {
 		return nil, fmt.Errorf("could not authenticate to MS Sentinel: %v", err)
 	}
 
 	sentinel.azCreds = azCreds
 
+	sentinel.tablesClient, err = insights.NewTablesClient(creds.SubscriptionID, azCreds, sentinel.armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("could not create ms graph table client: %v", err)
+	}
+
+	sentinel.alertRulesClient, err = security.NewAlertRulesClient(creds.SubscriptionID, azCreds, sentinel.armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("could not create sentinel alert rules client: %v", err)
+	}
+
+	sentinel.savedSearchesClient, err = insights.NewSavedSearchesClient(creds.SubscriptionID, azCreds, sentinel.armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("could not create saved searches client: %v", err)
+	}
+
+	sentinel.workbooksClient, err = workbooks.NewWorkbooksClient(creds.SubscriptionID, azCreds, sentinel.armOptions())
+	if err != nil {
+		return nil, fmt.Errorf("could not create workbooks client: %v", err)
+	}
+
 	return &sentinel, nil
 }
+
+// ingestClient returns the cached ingestion client of a data collection endpoint, creating it on first use.
+func (s *Sentinel) ingestClient(endpoint string) (*azlogs.Client, error) {
+	s.ingestClientsLock.Lock()
+	defer s.ingestClientsLock.Unlock()
+
+	if client, ok := s.ingestClients[endpoint]; ok {
+		return client, nil
+	}
+
+	client, err := azlogs.NewClient(endpoint, s.azCreds, &azlogs.ClientOptions{ClientOptions: s.clientOptions()})
+	if err != nil {
+		return nil, fmt.Errorf("could not create azure ingest client: %v", err)
+	}
+
+	s.ingestClients[endpoint] = client
+
+	return client, nil
+}
+
+// limiter returns the rate limiter shared by all uploads to a data collection rule.
+func (s *Sentinel) limiter(ruleID string) *rate.Limiter {
+	s.limitersLock.Lock()
+	defer s.limitersLock.Unlock()
+
+	if limiter, ok := s.limiters[ruleID]; ok {
+		return limiter
+	}
+
+	limit := rate.Inf
+	if s.options.RequestsPerSecond > 0 {
+		limit = rate.Limit(s.options.RequestsPerSecond)
+	}
+
+	limiter := rate.NewLimiter(limit, 1)
+	s.limiters[ruleID] = limiter
+
+	return limiter
+}
@@ -0,0 +1,101 @@
+package sentinel
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/sirupsen/logrus"
+	"sort"
+)
+
+// StreamDestination is where a stream is shipped to, the collector is used instead of the rule when it has a workspace.
+type StreamDestination struct {
+	Destination Destination
+	Collector   CollectorDestination
+}
+
+// checkDestination uploads an empty batch to the destination of a stream.
+func (s *Sentinel) checkDestination(ctx context.Context, destination StreamDestination) error {
+	emptyBatch := []byte("[]")
+
+	if destination.Collector.WorkspaceID != "" {
+		key, err := base64.StdEncoding.DecodeString(destination.Collector.SharedKey)
+		if err != nil {
+			return fmt.Errorf("could not decode shared key: %v", err)
+		}
+
+		if destination.Collector.TimeField == "" {
+			destination.Collector.TimeField = defaultCollectorTimeField
+		}
+
+		return s.postCollectorChunk(ctx, destination.Collector, key, emptyBatch)
+	}
+
+	return s.IngestLog(ctx, destination.Destination.Endpoint, destination.Destination.RuleID,
+		destination.Destination.StreamName, emptyBatch, 0, false)
+}
+
+// Sink ships streams to Sentinel through the Logs Ingestion API or the legacy HTTP Data Collector API.
+type Sink struct {
+	name         string
+	logger       *logrus.Logger
+	sentinel     *Sentinel
+	destinations map[sink.Stream]StreamDestination
+}
+
+func NewSink(logger *logrus.Logger, name string, sentinel *Sentinel, destinations map[sink.Stream]StreamDestination) *Sink {
+	return &Sink{
+		name:         name,
+		logger:       logger,
+		sentinel:     sentinel,
+		destinations: destinations,
+	}
+}
+
+func (s *Sink) Name() string {
+	return s.name
+}
+
+func (s *Sink) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	destination, ok := s.destinations[stream]
+	if !ok {
+		return 0, fmt.Errorf("no destination for stream '%s'", stream)
+	}
+
+	if destination.Collector.WorkspaceID != "" {
+		return s.sentinel.SendCollectorLogs(ctx, s.logger, destination.Collector, rows)
+	}
+
+	return s.sentinel.SendLogs(ctx, s.logger, destination.Destination, rows)
+}
+
+// Flush is a no-op since every chunk is uploaded, or spooled, by Write.
+func (s *Sink) Flush(_ context.Context) error {
+	return nil
+}
+
+func (s *Sink) Close() error {
+	return nil
+}
+
+// Health uploads an empty batch to every destination, which checks the endpoint can be reached,
+// the rule or workspace exists and the credentials are allowed to write to it without ingesting anything.
+func (s *Sink) Health(ctx context.Context) error {
+	streams := make([]string, 0, len(s.destinations))
+	for stream := range s.destinations {
+		streams = append(streams, string(stream))
+	}
+
+	sort.Strings(streams)
+
+	for _, stream := range streams {
+		destination := s.destinations[sink.Stream(stream)]
+
+		if err := s.sentinel.checkDestination(ctx, destination); err != nil {
+			return fmt.Errorf("destination of stream '%s' is unhealthy: %v", stream, err)
+		}
+	}
+
+	return nil
+}
@@ -10,152 +10,119 @@ import (
 	"time"
 )
 
-func (s *Sentinel) CreateNetworkTable(ctx context.Context, l *logrus.Logger, tableName string, retentionDays uint32) error {
-	logger := l.WithField("module", "sentinel_network")
+const (
+	// TablePlanAnalytics keeps logs queryable by analytics rules, the default plan.
+	TablePlanAnalytics = string(insights.TablePlanEnumAnalytics)
+	// TablePlanBasic is cheaper to ingest but has a fixed interactive retention and limited queries.
+	TablePlanBasic = string(insights.TablePlanEnumBasic)
+	// TablePlanAuxiliary is the cheapest plan for verbose logs, it can only be chosen when the table is created.
+	TablePlanAuxiliary = "Auxiliary"
+
+	minRetentionDays      = 4
+	maxRetentionDays      = 730
+	minTotalRetentionDays = 4
+	maxTotalRetentionDays = 4383
+)
 
-	tablesClient, err := insights.NewTablesClient(s.creds.SubscriptionID, s.azCreds, nil)
-	if err != nil {
-		return fmt.Errorf("could not create ms graph table client: %v", err)
+var (
+	// longTermRetentionDays are the only total retentions allowed beyond two years, in whole years
+	longTermRetentionDays = map[uint32]struct{}{
+		1095: {}, 1460: {}, 1826: {}, 2191: {}, 2556: {}, 2922: {}, 3288: {}, 3653: {}, 4018: {}, 4383: {},
 	}
+)
 
-	retention := int32(retentionDays)
+// TableOptions configures the plan and retention of a table, zero values keep the workspace defaults.
+type TableOptions struct {
+	Plan string
+	// RetentionDays is the interactive retention, only configurable for the analytics plan.
+	RetentionDays uint32
+	// TotalRetentionDays includes the long-term (archive) retention after the interactive retention.
+	TotalRetentionDays uint32
+}
 
-	logger.WithField("table_name", tableName).Info("creating or updating table")
+// Validate checks the options against the ranges allowed by Azure.
+func (o *TableOptions) Validate() error {
+	switch o.Plan {
+	case "", TablePlanAnalytics:
+		if o.RetentionDays != 0 && (o.RetentionDays < minRetentionDays || o.RetentionDays > maxRetentionDays) {
+			return fmt.Errorf("retention of %d days is outside %d-%d days", o.RetentionDays, minRetentionDays, maxRetentionDays)
+		}
+	case TablePlanBasic, TablePlanAuxiliary:
+		if o.RetentionDays != 0 {
+			return fmt.Errorf("the interactive retention of the %s plan is fixed and can not be set", o.Plan)
+		}
+	default:
+		return fmt.Errorf("unknown table plan '%s'", o.Plan)
+	}
 
-	if _, err = tablesClient.Migrate(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, tableName, nil); err != nil {
-		logger.WithError(err).Debug("could not migrate table")
+	if o.TotalRetentionDays == 0 {
+		return nil
 	}
 
-	poller, err := tablesClient.BeginCreateOrUpdate(ctx,
-		s.creds.ResourceGroup, s.creds.WorkspaceName, tableName,
-		insights.Table{
-			Properties: &insights.TableProperties{
-				RetentionInDays:      &retention,
-				TotalRetentionInDays: to.Ptr[int32](retention * 2),
-				Schema: &insights.Schema{
-					Columns: []*insights.Column{
-						{
-							Name: to.Ptr[string]("TimeGenerated"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDateTime),
-						},
-						{
-							Name: to.Ptr[string]("NodeID"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Start"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDateTime),
-						},
-						{
-							Name: to.Ptr[string]("End"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDateTime),
-						},
-						{
-							Name: to.Ptr[string]("Index"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumInt),
-						},
-						{
-							Name: to.Ptr[string]("Protocol"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Src"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Dst"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Bytes"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumInt),
-						},
-						{
-							Name: to.Ptr[string]("Packets"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumInt),
-						},
-					},
-					Name:        to.Ptr[string](tableName),
-					Description: to.Ptr[string]("Table that contains events ingested from 1Password."),
-				},
-			},
-		}, nil)
-	if err != nil {
-		return fmt.Errorf("could not create table '%s': %v", tableName, err)
+	if o.TotalRetentionDays < minTotalRetentionDays || o.TotalRetentionDays > maxTotalRetentionDays {
+		return fmt.Errorf("total retention of %d days is outside %d-%d days", o.TotalRetentionDays, minTotalRetentionDays, maxTotalRetentionDays)
 	}
 
-	_, err = poller.PollUntilDone(ctx, &runtime.PollUntilDoneOptions{Frequency: time.Second})
-	if err != nil {
-		return fmt.Errorf("could not poll table creation: %v", err)
+	if o.TotalRetentionDays < o.RetentionDays {
+		return fmt.Errorf("total retention of %d days is shorter than the retention of %d days", o.TotalRetentionDays, o.RetentionDays)
 	}
 
-	logger.WithField("table_name", tableName).Info("created table")
+	if _, ok := longTermRetentionDays[o.TotalRetentionDays]; o.TotalRetentionDays > maxRetentionDays && !ok {
+		return fmt.Errorf("total retention of %d days beyond %d days must be a whole amount of years", o.TotalRetentionDays, maxRetentionDays)
+	}
 
 	return nil
 }
 
-func (s *Sentinel) CreateAuditTable(ctx context.Context, l *logrus.Logger, tableName string, retentionDays uint32) error {
-	logger := l.WithField("module", "sentinel_audit")
+// CreateTable creates or updates the custom table of a schema in the workspace.
+func (s *Sentinel) CreateTable(ctx context.Context, l *logrus.Logger, workspace Workspace, schema *Schema, options TableOptions) error {
+	logger := l.WithField("module", "sentinel_table")
 
-	tablesClient, err := insights.NewTablesClient(s.creds.SubscriptionID, s.azCreds, nil)
-	if err != nil {
-		return fmt.Errorf("could not create ms graph table client: %v", err)
+	tableName := schema.TableName
+
+	if err := options.Validate(); err != nil {
+		return fmt.Errorf("invalid options for table '%s': %v", tableName, err)
+	}
+
+	columns := make([]*insights.Column, len(schema.Columns))
+	for i, column := range schema.Columns {
+		columns[i] = &insights.Column{
+			Name: to.Ptr[string](column.Name),
+			Type: to.Ptr[insights.ColumnTypeEnum](column.Type),
+		}
+	}
+
+	properties := insights.TableProperties{
+		Schema: &insights.Schema{
+			Columns:     columns,
+			Name:        to.Ptr[string](tableName),
+			Description: to.Ptr[string](schema.Description),
+		},
+	}
+
+	if options.Plan != "" {
+		properties.Plan = to.Ptr(insights.TablePlanEnum(options.Plan))
 	}
 
-	retention := int32(retentionDays)
+	if options.RetentionDays != 0 {
+		properties.RetentionInDays = to.Ptr(int32(options.RetentionDays))
+	}
+
+	if options.TotalRetentionDays != 0 {
+		properties.TotalRetentionInDays = to.Ptr(int32(options.TotalRetentionDays))
+	}
 
-	logger.WithField("table_name", tableName).Info("creating or updating table")
+	logger.WithField("table_name", tableName).WithField("plan", options.Plan).
+		WithField("retention_days", options.RetentionDays).WithField("total_retention_days", options.TotalRetentionDays).
+		Info("creating or updating table")
 
-	if _, err = tablesClient.Migrate(ctx, s.creds.ResourceGroup, s.creds.WorkspaceName, tableName, nil); err != nil {
+	if _, err := s.tablesClient.Migrate(ctx, workspace.ResourceGroup, workspace.Name, tableName, nil); err != nil {
 		logger.WithError(err).Debug("could not migrate table")
 	}
 
-	poller, err := tablesClient.BeginCreateOrUpdate(ctx,
-		s.creds.ResourceGroup, s.creds.WorkspaceName, tableName,
-		insights.Table{
-			Properties: &insights.TableProperties{
-				RetentionInDays:      &retention,
-				TotalRetentionInDays: to.Ptr[int32](retention * 2),
-				Schema: &insights.Schema{
-					Columns: []*insights.Column{
-						{
-							Name: to.Ptr[string]("TimeGenerated"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDateTime),
-						},
-						{
-							Name: to.Ptr[string]("Action"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("ActionType"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Origin"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Actor"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Target"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumString),
-						},
-						{
-							Name: to.Ptr[string]("Old"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-						{
-							Name: to.Ptr[string]("New"),
-							Type: to.Ptr[insights.ColumnTypeEnum](insights.ColumnTypeEnumDynamic),
-						},
-					},
-					Name:        to.Ptr[string](tableName),
-					Description: to.Ptr[string]("Table that contains events ingested from 1Password."),
-				},
-			},
-		}, nil)
+	poller, err := s.tablesClient.BeginCreateOrUpdate(ctx,
+		workspace.ResourceGroup, workspace.Name, tableName,
+		insights.Table{Properties: &properties}, nil)
 	if err != nil {
 		return fmt.Errorf("could not create table '%s': %v", tableName, err)
 	}
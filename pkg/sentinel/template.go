@@ -0,0 +1,36 @@
+package sentinel
+
+import (
+	"bytes"
+	"fmt"
+	"text/template"
+)
+
+// TemplateParameters are substituted in the queries of the bundled rules and functions.
+type TemplateParameters struct {
+	AuditTable   string
+	NetworkTable string
+}
+
+// DefaultTemplateParameters points the queries at the tables of the schema registry.
+func DefaultTemplateParameters() TemplateParameters {
+	return TemplateParameters{
+		AuditTable:   AuditSchema.TableName,
+		NetworkTable: NetworkSchema.TableName,
+	}
+}
+
+// renderTemplate executes a text/template, failing on parameters that do not exist.
+func renderTemplate(name, text string, params TemplateParameters) (string, error) {
+	tmpl, err := template.New(name).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("could not parse template: %v", err)
+	}
+
+	var rendered bytes.Buffer
+	if err := tmpl.Execute(&rendered, params); err != nil {
+		return "", fmt.Errorf("could not execute template: %v", err)
+	}
+
+	return rendered.String(), nil
+}
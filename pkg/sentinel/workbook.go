@@ -0,0 +1,140 @@
+package sentinel
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/Azure/azure-sdk-for-go/sdk/azcore/to"
+	workbooks "github.com/Azure/azure-sdk-for-go/sdk/resourcemanager/applicationinsights/armapplicationinsights/v2"
+	"github.com/google/uuid"
+	"github.com/sirupsen/logrus"
+	"strings"
+)
+
+const (
+	workbookDisplayName = "Tailscale"
+
+	// kqlItemType is the workbook item type of a query
+	kqlItemType = 3
+)
+
+var (
+	//go:embed workbooks/tailscale.json
+	workbookData string
+
+	workbookID = uuid.NewSHA1(contentNamespace, []byte("workbook/tailscale")).String()
+)
+
+// scopeWorkbookQueries points every query of the workbook items at the workspace holding the function it calls,
+// so the workbook also works when the audit and network tables live in different workspaces.
+func scopeWorkbookQueries(items []interface{}, functionWorkspaces map[string]string) error {
+	for _, item := range items {
+		itemMap, ok := item.(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		content, ok := itemMap["content"].(map[string]interface{})
+		if !ok {
+			continue
+		}
+
+		// groups nest their own items
+		if nested, ok := content["items"].([]interface{}); ok {
+			if err := scopeWorkbookQueries(nested, functionWorkspaces); err != nil {
+				return err
+			}
+		}
+
+		if itemType, _ := itemMap["type"].(float64); itemType != kqlItemType {
+			continue
+		}
+
+		query, _ := content["query"].(string)
+
+		fields := strings.FieldsFunc(query, func(r rune) bool {
+			return r == ' ' || r == '\n' || r == '|' || r == '('
+		})
+		if len(fields) == 0 {
+			return fmt.Errorf("workbook item '%v' has no query", itemMap["name"])
+		}
+
+		workspaceID, ok := functionWorkspaces[fields[0]]
+		if !ok {
+			return fmt.Errorf("workbook item '%v' does not start with a bundled function", itemMap["name"])
+		}
+
+		content["crossComponentResources"] = []string{workspaceID}
+	}
+
+	return nil
+}
+
+// renderWorkbook scopes the queries of the bundled workbook to the workspaces of the given sources.
+func renderWorkbook(subscriptionID string, workspaces map[string]Workspace) (string, error) {
+	functions, err := FunctionTemplates()
+	if err != nil {
+		return "", err
+	}
+
+	functionWorkspaces := make(map[string]string, len(functions))
+	for _, function := range functions {
+		workspace, ok := workspaces[function.Source]
+		if !ok {
+			return "", fmt.Errorf("no workspace for source '%s'", function.Source)
+		}
+
+		functionWorkspaces[function.Name] = workspaceResourceID(subscriptionID, workspace)
+	}
+
+	var workbook map[string]interface{}
+	if err := json.Unmarshal([]byte(workbookData), &workbook); err != nil {
+		return "", fmt.Errorf("could not decode workbook: %v", err)
+	}
+
+	items, _ := workbook["items"].([]interface{})
+	if err := scopeWorkbookQueries(items, functionWorkspaces); err != nil {
+		return "", err
+	}
+
+	workbookBytes, err := json.Marshal(workbook)
+	if err != nil {
+		return "", fmt.Errorf("could not encode workbook: %v", err)
+	}
+
+	return string(workbookBytes), nil
+}
+
+// DeployWorkbook creates or updates the Tailscale workbook, attached to the Sentinel workspace of the audit source.
+// Its queries use the TailscaleAudit and TailscaleNetwork functions and run in the workspace each function was saved in.
+func (s *Sentinel) DeployWorkbook(ctx context.Context, l *logrus.Logger, workspaces map[string]Workspace, location string) error {
+	logger := l.WithField("module", "sentinel_workbook").WithField("workbook_name", workbookDisplayName)
+
+	serializedData, err := renderWorkbook(s.creds.SubscriptionID, workspaces)
+	if err != nil {
+		return fmt.Errorf("could not render workbook: %v", err)
+	}
+
+	workspace := workspaces[RuleSourceAudit]
+
+	logger.Info("creating or updating workbook")
+
+	if _, err := s.workbooksClient.CreateOrUpdate(ctx, workspace.ResourceGroup, workbookID, workbooks.Workbook{
+		Location: to.Ptr(location),
+		Kind:     to.Ptr(workbooks.WorkbookSharedTypeKindShared),
+		Properties: &workbooks.WorkbookProperties{
+			DisplayName:    to.Ptr(workbookDisplayName),
+			Category:       to.Ptr("sentinel"),
+			SerializedData: to.Ptr(serializedData),
+			SourceID:       to.Ptr(workspaceResourceID(s.creds.SubscriptionID, workspace)),
+			Version:        to.Ptr("Notebook/1.0"),
+		},
+	}, nil); err != nil {
+		return fmt.Errorf("could not deploy workbook: %v", err)
+	}
+
+	logger.Info("deployed workbook")
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"sync"
+)
+
+// Stream identifies the kind of converted logs that are written.
+type Stream string
+
+const (
+	StreamAudit   Stream = "audit"
+	StreamNetwork Stream = "network"
+)
+
+// Sink is a destination that converted logs are shipped to.
+type Sink interface {
+	// Name identifies the sink in logs and errors.
+	Name() string
+	// Write ships a batch of rows of a stream and returns how many leading rows were handled,
+	// rows after the first failure must never be counted. Sinks that also handled rows after the first failure
+	// report them with an error implementing PartialFailure.
+	Write(ctx context.Context, stream Stream, rows []map[string]string) (int, error)
+	// Flush makes every row accepted by Write durable at the destination.
+	Flush(ctx context.Context) error
+	// Close releases the connections of the sink.
+	Close() error
+	// Health checks the destination can be reached and written to.
+	Health(ctx context.Context) error
+}
+
+// PartialFailure is implemented by write errors of sinks that also handled rows after the first failed one.
+type PartialFailure interface {
+	// Handled marks every row of the batch that was handled.
+	Handled() []bool
+}
+
+// handledRows marks the rows a sink handled, the leading written rows and any reported by a partial failure.
+func handledRows(rows, written int, err error) []bool {
+	handled := make([]bool, rows)
+	for i := range min(written, rows) {
+		handled[i] = true
+	}
+
+	var partial PartialFailure
+	if errors.As(err, &partial) {
+		for i, ok := range partial.Handled() {
+			if i < rows && ok {
+				handled[i] = true
+			}
+		}
+	}
+
+	return handled
+}
+
+// Select returns the rows that are marked as handled.
+func Select(rows []map[string]string, handled []bool) []map[string]string {
+	selected := make([]map[string]string, 0, len(rows))
+	for i, row := range rows {
+		if i < len(handled) && handled[i] {
+			selected = append(selected, row)
+		}
+	}
+
+	return selected
+}
+
+// Target is a sink with its failure handling.
+type Target struct {
+	Sink Sink
+	// Optional sinks may fail without failing the run or holding back the checkpoint.
+	Optional bool
+}
+
+// FanOut writes every batch to all of its targets in parallel.
+type FanOut struct {
+	logger  *logrus.Logger
+	targets []Target
+}
+
+func NewFanOut(logger *logrus.Logger, targets ...Target) *FanOut {
+	return &FanOut{
+		logger:  logger,
+		targets: targets,
+	}
+}
+
+// Targets returns the sinks the fan-out writes to.
+func (f *FanOut) Targets() []Target {
+	return f.targets
+}
+
+// Write ships the rows to every target and flushes them. It marks the rows that were handled by all required targets,
+// so only those are checkpointed. Failing optional targets are only logged.
+// Without required targets the rows of the optional target that handled the most are marked instead, failing only
+// when none of them handled any rows.
+func (f *FanOut) Write(ctx context.Context, stream Stream, rows []map[string]string) ([]bool, error) {
+	logger := f.logger.WithField("module", "sink").WithField("stream", stream)
+
+	handled := make([][]bool, len(f.targets))
+	errs := make([]error, len(f.targets))
+
+	var wg sync.WaitGroup
+
+	for i, target := range f.targets {
+		wg.Add(1)
+
+		go func() {
+			defer wg.Done()
+
+			written, err := target.Sink.Write(ctx, stream, rows)
+			if err == nil {
+				if flushErr := target.Sink.Flush(ctx); flushErr != nil {
+					// rows that are not flushed can not be relied on
+					written, err = 0, fmt.Errorf("could not flush: %v", flushErr)
+				}
+			}
+
+			handled[i], errs[i] = handledRows(len(rows), written, err), err
+		}()
+	}
+
+	wg.Wait()
+
+	checkpoint := make([]bool, len(rows))
+	for i := range checkpoint {
+		checkpoint[i] = true
+	}
+
+	var failed []error
+
+	// best is the optional target that handled the most rows, used when there are no required targets
+	required, best := false, -1
+
+	for i, target := range f.targets {
+		if target.Optional && (best < 0 || count(handled[i]) > count(handled[best])) {
+			best = i
+		}
+
+		sinkLogger := logger.WithField("sink", target.Sink.Name()).WithField("shipped", count(handled[i]))
+
+		if errs[i] == nil {
+			sinkLogger.Debug("wrote logs to sink")
+		} else if target.Optional {
+			sinkLogger.WithError(errs[i]).Warn("could not write logs to optional sink")
+			continue
+		} else {
+			sinkLogger.WithError(errs[i]).Error("could not write logs to sink")
+			failed = append(failed, fmt.Errorf("sink '%s': %v", target.Sink.Name(), errs[i]))
+		}
+
+		if target.Optional {
+			continue
+		}
+
+		required = true
+
+		for row, ok := range handled[i] {
+			checkpoint[row] = checkpoint[row] && ok
+		}
+	}
+
+	if !required {
+		if best < 0 {
+			return make([]bool, len(rows)), nil
+		}
+
+		// nothing was shipped anywhere, which must not pass as a successful run
+		if len(rows) > 0 && count(handled[best]) == 0 {
+			for i, target := range f.targets {
+				if errs[i] != nil {
+					failed = append(failed, fmt.Errorf("sink '%s': %v", target.Sink.Name(), errs[i]))
+				}
+			}
+
+			return handled[best], fmt.Errorf("no sink handled any of the %d rows: %v", len(rows), errors.Join(failed...))
+		}
+
+		return handled[best], nil
+	}
+
+	return checkpoint, errors.Join(failed...)
+}
+
+func count(handled []bool) int {
+	n := 0
+	for _, ok := range handled {
+		if ok {
+			n++
+		}
+	}
+
+	return n
+}
@@ -0,0 +1,170 @@
+package sink
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+// partialError reports rows handled after the first failed one.
+type partialError struct {
+	handled []bool
+}
+
+func (e *partialError) Error() string {
+	return "partial failure"
+}
+
+func (e *partialError) Handled() []bool {
+	return e.handled
+}
+
+// fakeSink handles the first written rows of every batch and fails with err.
+type fakeSink struct {
+	name     string
+	written  int
+	err      error
+	flushErr error
+}
+
+func (f *fakeSink) Name() string {
+	return f.name
+}
+
+func (f *fakeSink) Write(_ context.Context, _ Stream, rows []map[string]string) (int, error) {
+	return min(f.written, len(rows)), f.err
+}
+
+func (f *fakeSink) Flush(_ context.Context) error {
+	return f.flushErr
+}
+
+func (f *fakeSink) Close() error {
+	return nil
+}
+
+func (f *fakeSink) Health(_ context.Context) error {
+	return nil
+}
+
+func TestFanOutWrite(t *testing.T) {
+	failure := errors.New("failure")
+
+	tests := []struct {
+		name     string
+		targets  []Target
+		rows     int
+		expected []bool
+		fails    bool
+	}{
+		{
+			name:     "no targets",
+			rows:     2,
+			expected: []bool{false, false},
+		},
+		{
+			name:     "required target",
+			targets:  []Target{{Sink: &fakeSink{name: "a", written: 4}}},
+			rows:     4,
+			expected: []bool{true, true, true, true},
+		},
+		{
+			name: "required targets are combined",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 4}},
+				{Sink: &fakeSink{name: "b", written: 2, err: failure}},
+			},
+			rows:     4,
+			expected: []bool{true, true, false, false},
+			fails:    true,
+		},
+		{
+			name: "partial failure",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 1, err: &partialError{handled: []bool{true, false, true, true}}}},
+			},
+			rows:     4,
+			expected: []bool{true, false, true, true},
+			fails:    true,
+		},
+		{
+			name: "failed flush",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 4, flushErr: failure}},
+			},
+			rows:     4,
+			expected: []bool{false, false, false, false},
+			fails:    true,
+		},
+		{
+			name: "optional targets do not hold back the checkpoint",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 4}},
+				{Sink: &fakeSink{name: "b", written: 0, err: failure}, Optional: true},
+			},
+			rows:     4,
+			expected: []bool{true, true, true, true},
+		},
+		{
+			name: "optional targets do not extend the checkpoint",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 1, err: failure}},
+				{Sink: &fakeSink{name: "b", written: 4}, Optional: true},
+			},
+			rows:     4,
+			expected: []bool{true, false, false, false},
+			fails:    true,
+		},
+		{
+			name: "best optional target",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 1, err: failure}, Optional: true},
+				{Sink: &fakeSink{name: "b", written: 3, err: failure}, Optional: true},
+			},
+			rows:     4,
+			expected: []bool{true, true, true, false},
+		},
+		{
+			name: "every optional target failed",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 0, err: failure}, Optional: true},
+				{Sink: &fakeSink{name: "b", written: 0, err: failure}, Optional: true},
+			},
+			rows:     2,
+			expected: []bool{false, false},
+			fails:    true,
+		},
+		{
+			name: "optional targets without rows",
+			targets: []Target{
+				{Sink: &fakeSink{name: "a", written: 0, err: failure}, Optional: true},
+			},
+			rows:     0,
+			expected: []bool{},
+		},
+	}
+
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows := make([]map[string]string, test.rows)
+			for i := range rows {
+				rows[i] = map[string]string{"EventId": fmt.Sprintf("event-%d", i)}
+			}
+
+			handled, err := NewFanOut(logger, test.targets...).Write(context.Background(), StreamAudit, rows)
+			if (err != nil) != test.fails {
+				t.Fatalf("got error %v, expected failure %v", err, test.fails)
+			}
+
+			if fmt.Sprint(handled) != fmt.Sprint(test.expected) {
+				t.Fatalf("handled %v, expected %v", handled, test.expected)
+			}
+		})
+	}
+}
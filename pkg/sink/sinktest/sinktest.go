@@ -0,0 +1,36 @@
+// Package sinktest provides the fixtures shared by the tests of the sinks.
+package sinktest
+
+import (
+	"fmt"
+	"github.com/sirupsen/logrus"
+	"io"
+	"maps"
+)
+
+// Time is the TimeGenerated of every fixture row.
+const Time = "2024-05-01T12:00:00Z"
+
+// Logger returns a logger that discards its output.
+func Logger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+
+	return logger
+}
+
+// Rows returns n rows with the EventIds event-<from> onwards, every row holds a copy of columns.
+func Rows(from, n int, columns map[string]string) []map[string]string {
+	rows := make([]map[string]string, n)
+	for i := range rows {
+		rows[i] = maps.Clone(columns)
+		if rows[i] == nil {
+			rows[i] = make(map[string]string, 2)
+		}
+
+		rows[i]["TimeGenerated"] = Time
+		rows[i]["EventId"] = fmt.Sprintf("event-%d", from+i)
+	}
+
+	return rows
+}
@@ -0,0 +1,390 @@
+package splunk
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/google/uuid"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	eventPath  = "/services/collector/event"
+	ackPath    = "/services/collector/ack"
+	healthPath = "/services/collector/health"
+
+	defaultSource        = "tail2sen"
+	defaultMaxBatchBytes = 1000 * 1000 // 1MB, the default max_content_length of older indexers
+	defaultAckTimeout    = time.Minute
+	ackInterval          = time.Second
+)
+
+var (
+	defaultSourceTypes = map[sink.Stream]string{
+		sink.StreamAudit:   "tailscale:audit",
+		sink.StreamNetwork: "tailscale:network",
+	}
+)
+
+// StreamOptions decides where the events of a stream are indexed, empty values use the token defaults.
+type StreamOptions struct {
+	Index      string
+	SourceType string
+	Source     string
+}
+
+type Options struct {
+	// URL is the base url of the HTTP Event Collector, e.g. https://splunk:8088.
+	URL   string
+	Token string
+	TLS   utils.TLSOptions
+
+	DisableCompression bool
+	// MaxBatchBytes bounds the uncompressed size of a single request, defaults to 1MB.
+	MaxBatchBytes int
+
+	// Ack waits for indexer acknowledgement of every batch, the token must have it enabled.
+	Ack        bool
+	AckTimeout time.Duration
+	// Channel identifies the client for acknowledgements, defaults to a random id.
+	Channel string
+
+	Streams map[sink.Stream]StreamOptions
+}
+
+// Splunk is a sink that sends rows as events to a Splunk HTTP Event Collector.
+type Splunk struct {
+	name       string
+	logger     *logrus.Logger
+	options    Options
+	httpClient *http.Client
+}
+
+type event struct {
+	Time       float64           `json:"time,omitempty"`
+	Source     string            `json:"source,omitempty"`
+	SourceType string            `json:"sourcetype,omitempty"`
+	Index      string            `json:"index,omitempty"`
+	Event      map[string]string `json:"event"`
+}
+
+type eventResponse struct {
+	Text  string `json:"text"`
+	Code  int    `json:"code"`
+	AckID *int64 `json:"ackId"`
+}
+
+type ackResponse struct {
+	Acks map[string]bool `json:"acks"`
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*Splunk, error) {
+	if options.URL == "" {
+		return nil, fmt.Errorf("no splunk url provided")
+	}
+	if options.Token == "" {
+		return nil, fmt.Errorf("no splunk token provided")
+	}
+
+	options.URL = strings.TrimSuffix(options.URL, "/")
+
+	if options.MaxBatchBytes <= 0 {
+		options.MaxBatchBytes = defaultMaxBatchBytes
+	}
+	if options.AckTimeout <= 0 {
+		options.AckTimeout = defaultAckTimeout
+	}
+	if options.Channel == "" {
+		options.Channel = uuid.NewString()
+	}
+
+	tlsConfig, err := options.TLS.Config()
+	if err != nil {
+		return nil, err
+	}
+
+	return &Splunk{
+		name:       name,
+		logger:     logger,
+		options:    options,
+		httpClient: utils.NewLogHttpClientWithTLS(logger, tlsConfig),
+	}, nil
+}
+
+func (s *Splunk) Name() string {
+	return s.name
+}
+
+// streamOptions returns the index settings of a stream, with the default source and source type filled in.
+func (s *Splunk) streamOptions(stream sink.Stream) StreamOptions {
+	options := s.options.Streams[stream]
+
+	if options.Source == "" {
+		options.Source = defaultSource
+	}
+	if options.SourceType == "" {
+		options.SourceType = defaultSourceTypes[stream]
+	}
+
+	return options
+}
+
+// batch is a newline delimited set of events and the amount of rows it holds.
+type batch struct {
+	payload []byte
+	rows    int
+}
+
+// batches encodes the rows as events into batches that stay under the maximum batch size.
+func (s *Splunk) batches(stream sink.Stream, rows []map[string]string) ([]batch, error) {
+	options := s.streamOptions(stream)
+
+	var batches []batch
+	var current bytes.Buffer
+	currentRows := 0
+
+	for _, row := range rows {
+		e := event{
+			Source:     options.Source,
+			SourceType: options.SourceType,
+			Index:      options.Index,
+			Event:      row,
+		}
+
+		if eventTime, err := time.Parse(time.RFC3339, row["TimeGenerated"]); err == nil {
+			e.Time = float64(eventTime.UnixMilli()) / 1000
+		}
+
+		eventBytes, err := json.Marshal(&e)
+		if err != nil {
+			return nil, fmt.Errorf("could not encode event: %v", err)
+		}
+
+		if currentRows > 0 && current.Len()+len(eventBytes)+1 > s.options.MaxBatchBytes {
+			batches = append(batches, batch{payload: bytes.Clone(current.Bytes()), rows: currentRows})
+			current.Reset()
+			currentRows = 0
+		}
+
+		current.Write(eventBytes)
+		current.WriteByte('\n')
+		currentRows++
+	}
+
+	if currentRows > 0 {
+		batches = append(batches, batch{payload: bytes.Clone(current.Bytes()), rows: currentRows})
+	}
+
+	return batches, nil
+}
+
+// request sends a request to the collector and decodes the json response into out.
+func (s *Splunk) request(ctx context.Context, method, path string, body []byte, compress bool, out interface{}) error {
+	var reader io.Reader
+	if body != nil {
+		if compress {
+			var compressed bytes.Buffer
+
+			gzipWriter := gzip.NewWriter(&compressed)
+			if _, err := gzipWriter.Write(body); err != nil {
+				return fmt.Errorf("could not compress request: %v", err)
+			}
+			if err := gzipWriter.Close(); err != nil {
+				return fmt.Errorf("could not compress request: %v", err)
+			}
+
+			body = compressed.Bytes()
+		}
+
+		reader = bytes.NewReader(body)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, s.options.URL+path, reader)
+	if err != nil {
+		return fmt.Errorf("could not create request: %v", err)
+	}
+
+	req.Header.Set("Authorization", "Splunk "+s.options.Token)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Splunk-Request-Channel", s.options.Channel)
+
+	if body != nil && compress {
+		req.Header.Set("Content-Encoding", "gzip")
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("bad http response: %v", err)
+	}
+
+	defer resp.Body.Close()
+
+	respBytes, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return fmt.Errorf("could not read response: %v", err)
+	}
+
+	if resp.StatusCode > 299 {
+		return fmt.Errorf("received error code %d: %s", resp.StatusCode, string(respBytes))
+	}
+
+	if out != nil {
+		if err := json.Unmarshal(respBytes, out); err != nil {
+			return fmt.Errorf("could not decode response: %v", err)
+		}
+	}
+
+	return nil
+}
+
+// Write sends the rows in batches and, with acknowledgement enabled, waits until the indexers confirmed them.
+// Batches are sent in order and sending stops at the first failure.
+func (s *Splunk) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	logger := s.logger.WithField("module", "splunk").WithField("sink", s.name).WithField("stream", stream)
+
+	batches, err := s.batches(stream, rows)
+	if err != nil {
+		return 0, err
+	}
+
+	logger.WithField("total", len(rows)).WithField("batches", len(batches)).Info("sending events")
+
+	sent := 0
+	ackIDs := make([]int64, 0, len(batches))
+
+	var sendErr error
+
+	for i, b := range batches {
+		logger.WithField("progress", fmt.Sprintf("%d/%d", i+1, len(batches))).Debug("sending batch")
+
+		var resp eventResponse
+		if err := s.request(ctx, http.MethodPost, eventPath, b.payload, !s.options.DisableCompression, &resp); err != nil {
+			sendErr = fmt.Errorf("could not send batch %d: %v", i+1, err)
+			break
+		}
+
+		if resp.Code != 0 {
+			sendErr = fmt.Errorf("batch %d was rejected: %s (code %d)", i+1, resp.Text, resp.Code)
+			break
+		}
+
+		if s.options.Ack {
+			if resp.AckID == nil {
+				sendErr = fmt.Errorf("no ack id returned, is indexer acknowledgement enabled for the token")
+				break
+			}
+
+			ackIDs = append(ackIDs, *resp.AckID)
+		}
+
+		sent++
+	}
+
+	acked := sent
+	if s.options.Ack && sent > 0 {
+		var ackErr error
+		acked, ackErr = s.waitForAcks(ctx, ackIDs)
+		if ackErr != nil && sendErr == nil {
+			sendErr = ackErr
+		}
+	}
+
+	shipped := 0
+	for _, b := range batches[:acked] {
+		shipped += b.rows
+	}
+
+	if sendErr != nil {
+		return shipped, sendErr
+	}
+
+	logger.WithField("total", shipped).Info("sent events")
+
+	return shipped, nil
+}
+
+// waitForAcks polls the acknowledgement of the ids and returns how many leading ids were acknowledged.
+func (s *Splunk) waitForAcks(ctx context.Context, ackIDs []int64) (int, error) {
+	acked := make(map[int64]bool, len(ackIDs))
+	deadline := time.Now().Add(s.options.AckTimeout)
+
+	for {
+		var pending []int64
+		for _, id := range ackIDs {
+			if !acked[id] {
+				pending = append(pending, id)
+			}
+		}
+
+		if len(pending) == 0 {
+			return len(ackIDs), nil
+		}
+
+		body, err := json.Marshal(map[string][]int64{"acks": pending})
+		if err != nil {
+			return 0, fmt.Errorf("could not encode ack request: %v", err)
+		}
+
+		var resp ackResponse
+		if err := s.request(ctx, http.MethodPost, ackPath, body, false, &resp); err != nil {
+			return leadingAcked(ackIDs, acked), fmt.Errorf("could not poll acks: %v", err)
+		}
+
+		for _, id := range pending {
+			if resp.Acks[fmt.Sprintf("%d", id)] {
+				acked[id] = true
+			}
+		}
+
+		if leadingAcked(ackIDs, acked) == len(ackIDs) {
+			return len(ackIDs), nil
+		}
+
+		if time.Now().After(deadline) {
+			return leadingAcked(ackIDs, acked), fmt.Errorf("timed out waiting for %d acks", len(ackIDs)-len(acked))
+		}
+
+		select {
+		case <-ctx.Done():
+			return leadingAcked(ackIDs, acked), ctx.Err()
+		case <-time.After(ackInterval):
+		}
+	}
+}
+
+func leadingAcked(ackIDs []int64, acked map[int64]bool) int {
+	for i, id := range ackIDs {
+		if !acked[id] {
+			return i
+		}
+	}
+
+	return len(ackIDs)
+}
+
+// Flush is a no-op since Write only returns once the events were accepted, or acknowledged.
+func (s *Splunk) Flush(_ context.Context) error {
+	return nil
+}
+
+func (s *Splunk) Close() error {
+	s.httpClient.CloseIdleConnections()
+	return nil
+}
+
+// Health checks the collector is available.
+func (s *Splunk) Health(ctx context.Context) error {
+	if err := s.request(ctx, http.MethodGet, healthPath, nil, false, nil); err != nil {
+		return fmt.Errorf("splunk collector is unhealthy: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,333 @@
+package splunk
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/sink/sinktest"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeHEC is an HTTP Event Collector that records the events it receives.
+type fakeHEC struct {
+	t *testing.T
+
+	lock     sync.Mutex
+	batches  [][]event
+	headers  []http.Header
+	ackPolls int
+
+	// failBatch answers the batch with that 1-based number with an http error
+	failBatch int
+	// rejectBatch answers the batch with that 1-based number with a non-zero code
+	rejectBatch int
+	// ack returns ack ids, which are acknowledged when acked returns true for them
+	ack   bool
+	acked func(id int64) bool
+}
+
+func (f *fakeHEC) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	body := io.Reader(r.Body)
+	if r.Header.Get("Content-Encoding") == "gzip" {
+		gzipReader, err := gzip.NewReader(r.Body)
+		if err != nil {
+			f.t.Errorf("could not decompress request: %v", err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		body = gzipReader
+	}
+
+	switch r.URL.Path {
+	case eventPath:
+		var events []event
+
+		scanner := bufio.NewScanner(body)
+		scanner.Buffer(nil, 10*1000*1000)
+
+		for scanner.Scan() {
+			var e event
+			if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+				f.t.Errorf("could not decode event: %v", err)
+			}
+
+			events = append(events, e)
+		}
+
+		f.batches = append(f.batches, events)
+		f.headers = append(f.headers, r.Header.Clone())
+		batch := len(f.batches)
+
+		switch {
+		case batch == f.failBatch:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(`{"text":"Server is busy","code":9}`))
+		case batch == f.rejectBatch:
+			_, _ = w.Write([]byte(`{"text":"Invalid data format","code":6}`))
+		case f.ack:
+			_, _ = fmt.Fprintf(w, `{"text":"Success","code":0,"ackId":%d}`, batch-1)
+		default:
+			_, _ = w.Write([]byte(`{"text":"Success","code":0}`))
+		}
+
+	case ackPath:
+		f.ackPolls++
+
+		var req map[string][]int64
+		if err := json.NewDecoder(body).Decode(&req); err != nil {
+			f.t.Errorf("could not decode ack request: %v", err)
+		}
+
+		acks := make(map[string]bool, len(req["acks"]))
+		for _, id := range req["acks"] {
+			acks[fmt.Sprintf("%d", id)] = f.acked(id)
+		}
+
+		_ = json.NewEncoder(w).Encode(&ackResponse{Acks: acks})
+
+	case healthPath:
+		_, _ = w.Write([]byte(`{"text":"HEC is healthy","code":17}`))
+
+	default:
+		w.WriteHeader(http.StatusNotFound)
+	}
+}
+
+func (f *fakeHEC) rows() int {
+	f.lock.Lock()
+	defer f.lock.Unlock()
+
+	n := 0
+	for _, events := range f.batches {
+		n += len(events)
+	}
+
+	return n
+}
+
+func newTestSplunk(t *testing.T, hec *fakeHEC, options Options) *Splunk {
+	t.Helper()
+
+	server := httptest.NewServer(hec)
+	t.Cleanup(server.Close)
+
+	options.URL = server.URL
+	options.Token = "secret-token"
+
+	splunk, err := New(sinktest.Logger(), "splunk", options)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	return splunk
+}
+
+func testRows(n int) []map[string]string {
+	return sinktest.Rows(0, n, map[string]string{"Action": strings.Repeat("x", 100)})
+}
+
+func TestBatchesStayUnderMaxBatchBytes(t *testing.T) {
+	const maxBatchBytes = 1000
+
+	splunk := newTestSplunk(t, &fakeHEC{t: t}, Options{MaxBatchBytes: maxBatchBytes})
+
+	rows := testRows(20)
+
+	batches, err := splunk.batches(sink.StreamAudit, rows)
+	if err != nil {
+		t.Fatalf("could not batch rows: %v", err)
+	}
+
+	if len(batches) < 2 {
+		t.Fatalf("expected the rows to be split over several batches, got %d", len(batches))
+	}
+
+	total := 0
+	for i, b := range batches {
+		if len(b.payload) > maxBatchBytes {
+			t.Errorf("batch %d is %d bytes, over the max of %d", i, len(b.payload), maxBatchBytes)
+		}
+
+		lines := bytes.Split(bytes.TrimSuffix(b.payload, []byte("\n")), []byte("\n"))
+		if len(lines) != b.rows {
+			t.Errorf("batch %d holds %d lines for %d rows", i, len(lines), b.rows)
+		}
+
+		if !bytes.HasSuffix(b.payload, []byte("\n")) {
+			t.Errorf("batch %d does not end with a newline", i)
+		}
+
+		total += b.rows
+	}
+
+	if total != len(rows) {
+		t.Fatalf("batches hold %d rows, expected %d", total, len(rows))
+	}
+}
+
+func TestWriteCompressesAndSetsStreamOptions(t *testing.T) {
+	hec := &fakeHEC{t: t}
+
+	splunk := newTestSplunk(t, hec, Options{
+		Streams: map[sink.Stream]StreamOptions{
+			sink.StreamAudit: {Index: "tailscale", SourceType: "custom:audit", Source: "tailnet"},
+		},
+	})
+
+	for _, stream := range []sink.Stream{sink.StreamAudit, sink.StreamNetwork} {
+		written, err := splunk.Write(context.Background(), stream, testRows(3))
+		if err != nil {
+			t.Fatalf("could not write %s rows: %v", stream, err)
+		}
+
+		if written != 3 {
+			t.Fatalf("wrote %d %s rows, expected 3", written, stream)
+		}
+	}
+
+	if len(hec.batches) != 2 {
+		t.Fatalf("expected a batch per stream, got %d", len(hec.batches))
+	}
+
+	for _, header := range hec.headers {
+		if got := header.Get("Content-Encoding"); got != "gzip" {
+			t.Errorf("expected gzip content encoding, got '%s'", got)
+		}
+
+		if got := header.Get("Authorization"); got != "Splunk secret-token" {
+			t.Errorf("unexpected authorization header '%s'", got)
+		}
+	}
+
+	expected := []event{
+		{Index: "tailscale", SourceType: "custom:audit", Source: "tailnet"},
+		{Index: "", SourceType: "tailscale:network", Source: defaultSource},
+	}
+
+	for i, events := range hec.batches {
+		for _, e := range events {
+			if e.Index != expected[i].Index || e.SourceType != expected[i].SourceType || e.Source != expected[i].Source {
+				t.Errorf("batch %d: got index '%s', sourcetype '%s', source '%s'", i, e.Index, e.SourceType, e.Source)
+			}
+
+			if e.Time != 1714564800 {
+				t.Errorf("batch %d: unexpected event time %f", i, e.Time)
+			}
+		}
+	}
+}
+
+func TestWriteUncompressed(t *testing.T) {
+	hec := &fakeHEC{t: t}
+
+	splunk := newTestSplunk(t, hec, Options{DisableCompression: true})
+
+	if _, err := splunk.Write(context.Background(), sink.StreamAudit, testRows(2)); err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if got := hec.headers[0].Get("Content-Encoding"); got != "" {
+		t.Fatalf("expected no content encoding, got '%s'", got)
+	}
+
+	if hec.rows() != 2 {
+		t.Fatalf("expected 2 events, got %d", hec.rows())
+	}
+}
+
+func TestWriteWaitsForAcks(t *testing.T) {
+	hec := &fakeHEC{t: t, ack: true, acked: func(int64) bool { return true }}
+
+	splunk := newTestSplunk(t, hec, Options{Ack: true, MaxBatchBytes: 1000})
+
+	rows := testRows(20)
+
+	written, err := splunk.Write(context.Background(), sink.StreamAudit, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	if hec.ackPolls == 0 {
+		t.Fatal("acknowledgements were never polled")
+	}
+}
+
+func TestWriteAckTimeout(t *testing.T) {
+	// only the first batch is ever acknowledged
+	hec := &fakeHEC{t: t, ack: true, acked: func(id int64) bool { return id == 0 }}
+
+	splunk := newTestSplunk(t, hec, Options{Ack: true, AckTimeout: time.Millisecond, MaxBatchBytes: 1000})
+
+	rows := testRows(20)
+
+	written, err := splunk.Write(context.Background(), sink.StreamAudit, rows)
+	if err == nil {
+		t.Fatal("expected an ack timeout")
+	}
+
+	if !strings.Contains(err.Error(), "timed out") {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if written != len(hec.batches[0]) {
+		t.Fatalf("wrote %d rows, expected only the %d rows of the acked batch", written, len(hec.batches[0]))
+	}
+}
+
+func TestWriteReturnsPrefixOnFailure(t *testing.T) {
+	tests := []struct {
+		name string
+		hec  *fakeHEC
+	}{
+		{name: "http error", hec: &fakeHEC{failBatch: 2}},
+		{name: "rejected batch", hec: &fakeHEC{rejectBatch: 2}},
+		{name: "http error after ack", hec: &fakeHEC{failBatch: 2, ack: true, acked: func(int64) bool { return true }}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.hec.t = t
+
+			splunk := newTestSplunk(t, test.hec, Options{Ack: test.hec.ack, MaxBatchBytes: 1000})
+
+			written, err := splunk.Write(context.Background(), sink.StreamAudit, testRows(20))
+			if err == nil {
+				t.Fatal("expected the second batch to fail")
+			}
+
+			if len(test.hec.batches) != 2 {
+				t.Fatalf("expected sending to stop after the failed batch, got %d batches", len(test.hec.batches))
+			}
+
+			if written != len(test.hec.batches[0]) {
+				t.Fatalf("wrote %d rows, expected the %d rows of the first batch", written, len(test.hec.batches[0]))
+			}
+		})
+	}
+}
+
+func TestHealth(t *testing.T) {
+	splunk := newTestSplunk(t, &fakeHEC{t: t}, Options{})
+
+	if err := splunk.Health(context.Background()); err != nil {
+		t.Fatalf("expected a healthy collector: %v", err)
+	}
+}
@@ -0,0 +1,158 @@
+package syslog
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	cefVendor  = "Tailscale"
+	cefProduct = "tail2sen"
+	cefVersion = "1.0"
+
+	cefSeverityAudit   = 3
+	cefSeverityNetwork = 1
+)
+
+var (
+	cefHeaderEscaper    = strings.NewReplacer(`\`, `\\`, `|`, `\|`, "\r", " ", "\n", " ")
+	cefExtensionEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+)
+
+// extension holds the key value pairs of a CEF message, empty values are left out.
+type extension map[string]string
+
+func (e extension) set(key, value string) {
+	if value != "" {
+		e[key] = value
+	}
+}
+
+// setCustom stores a value in a custom field together with its label.
+func (e extension) setCustom(key, label, value string) {
+	if value != "" {
+		e[key] = value
+		e[key+"Label"] = label
+	}
+}
+
+// setTime stores a timestamp column as milliseconds since the epoch.
+func (e extension) setTime(key, value string) {
+	if t, err := time.Parse(time.RFC3339Nano, value); err == nil {
+		e[key] = strconv.FormatInt(t.UnixMilli(), 10)
+	}
+}
+
+// setEndpoint splits an ip:port column, CEF only allows IPv4 in the address fields so IPv6 uses the custom ones.
+func (e extension) setEndpoint(addressKey, ipv6Key, ipv6Label, portKey, value string) {
+	host, port, err := net.SplitHostPort(value)
+	if err != nil {
+		host = value
+	}
+
+	ip := net.ParseIP(host)
+	switch {
+	case ip == nil:
+	case ip.To4() != nil:
+		e.set(addressKey, host)
+	default:
+		e.setCustom(ipv6Key, ipv6Label, host)
+	}
+
+	e.set(portKey, port)
+}
+
+func (e extension) String() string {
+	keys := make([]string, 0, len(e))
+	for key := range e {
+		keys = append(keys, key)
+	}
+
+	sort.Strings(keys)
+
+	pairs := make([]string, len(keys))
+	for i, key := range keys {
+		pairs[i] = key + "=" + cefExtensionEscaper.Replace(e[key])
+	}
+
+	return strings.Join(pairs, " ")
+}
+
+// toCEF formats a row as a CEF message, mapping the columns onto the CEF dictionary where one exists.
+func toCEF(stream sink.Stream, row map[string]string) string {
+	ext := extension{}
+
+	ext.setTime("rt", row["TimeGenerated"])
+	ext.set("externalId", row["EventId"])
+
+	var signatureID, name string
+	var severity int
+
+	switch stream {
+	case sink.StreamAudit:
+		severity = cefSeverityAudit
+		signatureID = row["Action"]
+		name = "Tailscale " + strings.ToLower(row["Action"])
+
+		ext.set("act", row["Action"])
+		ext.set("cat", row["ActionType"])
+
+		var actor struct {
+			ID        string `json:"id"`
+			LoginName string `json:"loginName"`
+		}
+		if json.Unmarshal([]byte(row["Actor"]), &actor) == nil {
+			ext.set("suid", actor.ID)
+			ext.set("suser", actor.LoginName)
+		}
+
+		var target struct {
+			ID   string `json:"id"`
+			Name string `json:"name"`
+			Type string `json:"type"`
+		}
+		if json.Unmarshal([]byte(row["Target"]), &target) == nil {
+			if strings.EqualFold(target.Type, "USER") {
+				ext.set("duid", target.ID)
+				ext.set("duser", target.Name)
+			}
+
+			ext.setCustom("cs1", "TargetName", target.Name)
+			ext.setCustom("cs2", "TargetType", target.Type)
+			name = strings.TrimSpace(name + " " + strings.ToLower(target.Type))
+		}
+
+		ext.setCustom("cs3", "Origin", row["Origin"])
+		ext.setCustom("cs4", "Changes", row["Changes"])
+
+	case sink.StreamNetwork:
+		severity = cefSeverityNetwork
+		signatureID = "flow:" + row["TrafficType"]
+		name = "Tailscale " + row["TrafficType"] + " traffic"
+
+		ext.setTime("start", row["Start"])
+		ext.setTime("end", row["End"])
+		ext.set("deviceExternalId", row["NodeID"])
+		ext.set("proto", row["Protocol"])
+		ext.setEndpoint("src", "c6a2", "Source IPv6 Address", "spt", row["Src"])
+		ext.setEndpoint("dst", "c6a3", "Destination IPv6 Address", "dpt", row["Dst"])
+		ext.set("in", row["Bytes"])
+		ext.set("cnt", row["Packets"])
+		ext.setCustom("cs5", "SourceCountry", row["SrcCountry"])
+		ext.setCustom("cs6", "DestinationCountry", row["DstCountry"])
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		cefVendor, cefProduct, cefVersion,
+		cefHeaderEscaper.Replace(signatureID),
+		cefHeaderEscaper.Replace(name),
+		severity,
+		ext,
+	)
+}
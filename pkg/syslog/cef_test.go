@@ -0,0 +1,234 @@
+package syslog
+
+import (
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"strings"
+	"testing"
+)
+
+// parseCEF splits a CEF message into its seven unescaped header fields and its raw extension.
+func parseCEF(t *testing.T, message string) ([]string, string) {
+	t.Helper()
+
+	var fields []string
+	var field strings.Builder
+
+	for i := 0; i < len(message); i++ {
+		switch {
+		case len(fields) == 7:
+			return fields, message[i:]
+		case message[i] == '\\' && i+1 < len(message):
+			field.WriteByte(message[i+1])
+			i++
+		case message[i] == '|':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(message[i])
+		}
+	}
+
+	if len(fields) != 7 {
+		t.Fatalf("message has %d header fields, expected 7: %s", len(fields), message)
+	}
+
+	return fields, ""
+}
+
+// parseExtension splits the key value pairs of a CEF extension, unescaping the values.
+// Keys never hold spaces, so a key starts after the last space before an unescaped =.
+func parseExtension(t *testing.T, ext string) map[string]string {
+	t.Helper()
+
+	var separators []int
+	for i := 0; i < len(ext); i++ {
+		switch ext[i] {
+		case '\\':
+			i++
+		case '=':
+			separators = append(separators, i)
+		}
+	}
+
+	keyStart := func(separator int) int {
+		return strings.LastIndex(ext[:separator], " ") + 1
+	}
+
+	unescaper := strings.NewReplacer(`\\`, `\`, `\=`, `=`, `\r`, "\r", `\n`, "\n")
+
+	pairs := make(map[string]string, len(separators))
+	for i, separator := range separators {
+		end := len(ext)
+		if i+1 < len(separators) {
+			end = keyStart(separators[i+1]) - 1
+		}
+
+		if end < separator+1 {
+			t.Fatalf("could not parse extension: %s", ext)
+		}
+
+		pairs[ext[keyStart(separator):separator]] = unescaper.Replace(ext[separator+1 : end])
+	}
+
+	return pairs
+}
+
+func TestCEFHeaderEscaping(t *testing.T) {
+	message := toCEF(sink.StreamAudit, map[string]string{
+		"Action": `UPDATE|POLICY\x` + "\nnext",
+	})
+
+	if strings.Contains(message, "\n") {
+		t.Fatalf("message holds a newline: %q", message)
+	}
+
+	if !strings.Contains(message, `|UPDATE\|POLICY\\x next|`) {
+		t.Fatalf("signature id is not escaped: %s", message)
+	}
+
+	fields, _ := parseCEF(t, message)
+
+	expected := []string{"CEF:0", cefVendor, cefProduct, cefVersion, `UPDATE|POLICY\x next`, `Tailscale update|policy\x next`, "3"}
+	for i, field := range expected {
+		if fields[i] != field {
+			t.Errorf("header field %d is '%s', expected '%s'", i, fields[i], field)
+		}
+	}
+}
+
+func TestCEFExtensionEscaping(t *testing.T) {
+	changes := "a=b\\c\r\nd|e"
+
+	message := toCEF(sink.StreamAudit, map[string]string{
+		"Action":  "CREATE",
+		"Changes": changes,
+	})
+
+	if !strings.Contains(message, `cs4=a\=b\\c\r\nd|e`) {
+		t.Fatalf("extension value is not escaped: %s", message)
+	}
+
+	_, ext := parseCEF(t, message)
+
+	if got := parseExtension(t, ext)["cs4"]; got != changes {
+		t.Fatalf("extension value is '%s', expected '%s'", got, changes)
+	}
+}
+
+func TestCEFAuditMapping(t *testing.T) {
+	message := toCEF(sink.StreamAudit, map[string]string{
+		"TimeGenerated": "2024-05-01T12:00:00Z",
+		"EventId":       "event-1",
+		"Action":        "DELETE",
+		"ActionType":    "CONFIG",
+		"Origin":        "ADMIN_CONSOLE",
+		"Actor":         `{"id":"u1","loginName":"alice@example.com","type":"USER"}`,
+		"Target":        `{"id":"u2","name":"bob@example.com","type":"USER"}`,
+	})
+
+	fields, ext := parseCEF(t, message)
+
+	if fields[4] != "DELETE" || fields[5] != "Tailscale delete user" || fields[6] != "3" {
+		t.Errorf("unexpected header %v", fields)
+	}
+
+	expected := map[string]string{
+		"rt":         "1714564800000",
+		"externalId": "event-1",
+		"act":        "DELETE",
+		"cat":        "CONFIG",
+		"suid":       "u1",
+		"suser":      "alice@example.com",
+		"duid":       "u2",
+		"duser":      "bob@example.com",
+		"cs1":        "bob@example.com",
+		"cs1Label":   "TargetName",
+		"cs2":        "USER",
+		"cs2Label":   "TargetType",
+		"cs3":        "ADMIN_CONSOLE",
+		"cs3Label":   "Origin",
+	}
+
+	assertExtension(t, parseExtension(t, ext), expected)
+}
+
+func TestCEFNetworkMapping(t *testing.T) {
+	tests := []struct {
+		name     string
+		row      map[string]string
+		expected map[string]string
+	}{
+		{
+			name: "ipv4",
+			row: map[string]string{
+				"TrafficType": "virtual",
+				"NodeID":      "node1",
+				"Protocol":    "6",
+				"Src":         "100.64.0.1:51234",
+				"Dst":         "100.64.0.2:443",
+				"Bytes":       "1200",
+				"Packets":     "4",
+				"Start":       "2024-05-01T12:00:00Z",
+				"End":         "2024-05-01T12:00:05Z",
+			},
+			expected: map[string]string{
+				"deviceExternalId": "node1",
+				"proto":            "6",
+				"src":              "100.64.0.1",
+				"spt":              "51234",
+				"dst":              "100.64.0.2",
+				"dpt":              "443",
+				"in":               "1200",
+				"cnt":              "4",
+				"start":            "1714564800000",
+				"end":              "1714564805000",
+			},
+		},
+		{
+			name: "ipv6",
+			row: map[string]string{
+				"TrafficType": "exit",
+				"Src":         "[fd7a:115c:a1e0::1]:41641",
+				"Dst":         "[2001:db8::1]:80",
+			},
+			expected: map[string]string{
+				"c6a2":      "fd7a:115c:a1e0::1",
+				"c6a2Label": "Source IPv6 Address",
+				"spt":       "41641",
+				"c6a3":      "2001:db8::1",
+				"c6a3Label": "Destination IPv6 Address",
+				"dpt":       "80",
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			fields, ext := parseCEF(t, toCEF(sink.StreamNetwork, test.row))
+
+			if fields[4] != "flow:"+test.row["TrafficType"] || fields[6] != "1" {
+				t.Errorf("unexpected header %v", fields)
+			}
+
+			pairs := parseExtension(t, ext)
+
+			assertExtension(t, pairs, test.expected)
+
+			if test.name == "ipv6" {
+				if _, ok := pairs["src"]; ok {
+					t.Error("an ipv6 source was mapped onto src")
+				}
+			}
+		})
+	}
+}
+
+func assertExtension(t *testing.T, pairs, expected map[string]string) {
+	t.Helper()
+
+	for key, value := range expected {
+		if pairs[key] != value {
+			t.Errorf("extension %s is '%s', expected '%s'", key, pairs[key], value)
+		}
+	}
+}
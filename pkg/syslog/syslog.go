@@ -0,0 +1,341 @@
+package syslog
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/utils"
+	"github.com/sirupsen/logrus"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	NetworkUDP = "udp"
+	NetworkTCP = "tcp"
+	NetworkTLS = "tls"
+
+	// FormatStructured sends the columns of a row as RFC 5424 structured data.
+	FormatStructured = "structured"
+	// FormatCEF sends a row as a CEF message.
+	FormatCEF = "cef"
+
+	// FramingOctetCounting prefixes every message with its length, as RFC 5425 requires for TLS.
+	FramingOctetCounting = "octet-counting"
+	// FramingNonTransparent terminates every message with a newline, which some receivers require.
+	FramingNonTransparent = "non-transparent"
+
+	defaultAppName      = "tail2sen"
+	defaultFacility     = 16 // local0
+	defaultWriteTimeout = 30 * time.Second
+	// defaultMaxMessageSize is the datagram size RFC 5426 requires every udp receiver to accept
+	defaultMaxMessageSize = 2048
+
+	// maxReconnects is how often a message is resent over a new connection before giving up
+	maxReconnects  = 3
+	reconnectDelay = time.Second
+)
+
+type Options struct {
+	// Network is udp, tcp or tls.
+	Network string
+	// Address is the host:port of the receiver.
+	Address string
+	TLS     utils.TLSOptions
+
+	// Format is structured or cef, defaults to structured.
+	Format string
+	// Framing of messages over tcp and tls, defaults to octet-counting.
+	Framing string
+
+	Facility     int
+	Hostname     string
+	AppName      string
+	WriteTimeout time.Duration
+	// MaxMessageSize truncates udp messages to this many bytes, defaults to 2048.
+	MaxMessageSize int
+}
+
+// Syslog is a sink that sends every row as an RFC 5424 syslog message.
+type Syslog struct {
+	name    string
+	logger  *logrus.Logger
+	options Options
+
+	tlsConfig *tls.Config
+
+	lock sync.Mutex
+	conn net.Conn
+}
+
+func New(logger *logrus.Logger, name string, options Options) (*Syslog, error) {
+	if options.Address == "" {
+		return nil, fmt.Errorf("no syslog address provided")
+	}
+
+	switch options.Network {
+	case NetworkUDP, NetworkTCP, NetworkTLS:
+	case "":
+		options.Network = NetworkUDP
+	default:
+		return nil, fmt.Errorf("unknown syslog network '%s'", options.Network)
+	}
+
+	switch options.Format {
+	case FormatStructured, FormatCEF:
+	case "":
+		options.Format = FormatStructured
+	default:
+		return nil, fmt.Errorf("unknown syslog format '%s'", options.Format)
+	}
+
+	switch options.Framing {
+	case FramingOctetCounting, FramingNonTransparent:
+	case "":
+		options.Framing = FramingOctetCounting
+	default:
+		return nil, fmt.Errorf("unknown syslog framing '%s'", options.Framing)
+	}
+
+	if options.Facility < 0 || options.Facility > 23 {
+		return nil, fmt.Errorf("invalid syslog facility %d", options.Facility)
+	}
+	if options.Facility == 0 {
+		options.Facility = defaultFacility
+	}
+
+	if options.Hostname == "" {
+		options.Hostname, _ = os.Hostname()
+	}
+
+	if options.AppName == "" {
+		options.AppName = defaultAppName
+	}
+
+	if options.WriteTimeout <= 0 {
+		options.WriteTimeout = defaultWriteTimeout
+	}
+
+	if options.MaxMessageSize <= 0 {
+		options.MaxMessageSize = defaultMaxMessageSize
+	}
+
+	s := Syslog{
+		name:    name,
+		logger:  logger,
+		options: options,
+	}
+
+	if options.Network == NetworkTLS {
+		tlsConfig, err := options.TLS.Config()
+		if err != nil {
+			return nil, err
+		}
+
+		s.tlsConfig = tlsConfig
+	}
+
+	return &s, nil
+}
+
+func (s *Syslog) Name() string {
+	return s.name
+}
+
+// connect opens the connection to the receiver if there is none.
+func (s *Syslog) connect(ctx context.Context) error {
+	if s.conn != nil {
+		return nil
+	}
+
+	dialer := net.Dialer{Timeout: s.options.WriteTimeout}
+
+	var conn net.Conn
+	var err error
+
+	if s.options.Network == NetworkTLS {
+		tlsDialer := tls.Dialer{NetDialer: &dialer, Config: s.tlsConfig}
+		conn, err = tlsDialer.DialContext(ctx, "tcp", s.options.Address)
+	} else {
+		conn, err = dialer.DialContext(ctx, s.options.Network, s.options.Address)
+	}
+
+	if err != nil {
+		return fmt.Errorf("could not connect to '%s': %v", s.options.Address, err)
+	}
+
+	s.logger.WithField("module", "syslog").WithField("sink", s.name).WithField("address", s.options.Address).
+		WithField("network", s.options.Network).Debug("connected to syslog receiver")
+
+	s.conn = conn
+
+	return nil
+}
+
+// disconnect drops the connection so the next message reconnects.
+func (s *Syslog) disconnect() {
+	if s.conn != nil {
+		_ = s.conn.Close()
+		s.conn = nil
+	}
+}
+
+// dropClosed disconnects when the receiver closed the connection, since the first write to such a connection still
+// succeeds and its message would be lost. Receivers never send data, so any read result but a timeout means closed.
+func (s *Syslog) dropClosed() {
+	if s.conn == nil || s.options.Network == NetworkUDP {
+		return
+	}
+
+	if err := s.conn.SetReadDeadline(time.Now().Add(time.Millisecond)); err != nil {
+		s.disconnect()
+		return
+	}
+
+	var buf [1]byte
+	_, err := s.conn.Read(buf[:])
+
+	if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
+		return
+	}
+
+	s.logger.WithField("module", "syslog").WithField("sink", s.name).Debug("syslog receiver closed the connection")
+	s.disconnect()
+}
+
+// frame wraps a message for the transport, udp sends every message as a datagram of its own.
+func (s *Syslog) frame(message string) []byte {
+	if s.options.Network == NetworkUDP {
+		return []byte(message)
+	}
+
+	if s.options.Framing == FramingNonTransparent {
+		return []byte(message + "\n")
+	}
+
+	return []byte(strconv.Itoa(len(message)) + " " + message)
+}
+
+// truncate cuts a message to at most maxSize bytes without splitting a character, and returns whether it was cut.
+func truncate(message string, maxSize int) (string, bool) {
+	if len(message) <= maxSize {
+		return message, false
+	}
+
+	end := maxSize
+	for end > 0 && !utf8.RuneStart(message[end]) {
+		end--
+	}
+
+	return message[:end], true
+}
+
+// send writes a single framed message, reconnecting when the connection broke.
+func (s *Syslog) send(ctx context.Context, frame []byte) error {
+	var lastErr error
+
+	for attempt := 0; attempt <= maxReconnects; attempt++ {
+		if attempt > 0 {
+			s.logger.WithField("module", "syslog").WithField("sink", s.name).WithField("retry", attempt).
+				WithError(lastErr).Warn("reconnecting to syslog receiver")
+
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(reconnectDelay << (attempt - 1)):
+			}
+		}
+
+		if err := s.connect(ctx); err != nil {
+			lastErr = err
+			continue
+		}
+
+		if err := s.conn.SetWriteDeadline(time.Now().Add(s.options.WriteTimeout)); err != nil {
+			s.disconnect()
+			lastErr = fmt.Errorf("could not set write deadline: %v", err)
+			continue
+		}
+
+		if _, err := s.conn.Write(frame); err != nil {
+			// a partially written frame is lost with the connection, so the whole message is resent
+			s.disconnect()
+			lastErr = fmt.Errorf("could not write message: %v", err)
+			continue
+		}
+
+		return nil
+	}
+
+	return lastErr
+}
+
+// Write sends every row as a syslog message and returns how many leading rows were sent.
+// Syslog has no acknowledgements, so a row counts as sent once the transport accepted it.
+func (s *Syslog) Write(ctx context.Context, stream sink.Stream, rows []map[string]string) (int, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	logger := s.logger.WithField("module", "syslog").WithField("sink", s.name).WithField("stream", stream)
+
+	logger.WithField("total", len(rows)).Info("sending syslog messages")
+
+	s.dropClosed()
+
+	for i, row := range rows {
+		message := s.formatMessage(stream, row)
+
+		// a datagram over the size of the receiver or the network fails or is dropped on every attempt
+		if s.options.Network == NetworkUDP {
+			var truncated bool
+			if message, truncated = truncate(message, s.options.MaxMessageSize); truncated {
+				logger.WithField("event_id", row["EventId"]).WithField("max_size", s.options.MaxMessageSize).
+					Warn("truncated syslog message")
+			}
+		}
+
+		if err := s.send(ctx, s.frame(message)); err != nil {
+			return i, fmt.Errorf("could not send syslog message to '%s': %v", s.options.Address, err)
+		}
+	}
+
+	logger.WithField("total", len(rows)).Info("sent syslog messages")
+
+	return len(rows), nil
+}
+
+// Flush is a no-op since messages are written unbuffered.
+func (s *Syslog) Flush(_ context.Context) error {
+	return nil
+}
+
+func (s *Syslog) Close() error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.conn == nil {
+		return nil
+	}
+
+	err := s.conn.Close()
+	s.conn = nil
+
+	return err
+}
+
+// Health checks the receiver accepts connections, udp receivers can not be checked beyond resolving the address.
+func (s *Syslog) Health(ctx context.Context) error {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if err := s.connect(ctx); err != nil {
+		return fmt.Errorf("syslog receiver is unhealthy: %v", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package syslog
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"github.com/hazcod/tail2sen/pkg/sink"
+	"github.com/hazcod/tail2sen/pkg/sink/sinktest"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func newTestSyslog(t *testing.T, options Options) *Syslog {
+	t.Helper()
+
+	options.Hostname = "host"
+
+	syslog, err := New(sinktest.Logger(), "syslog", options)
+	if err != nil {
+		t.Fatalf("could not create sink: %v", err)
+	}
+
+	t.Cleanup(func() { _ = syslog.Close() })
+
+	return syslog
+}
+
+// listenTCP accepts a single connection and returns everything received on it once the sink closes it.
+func listenTCP(t *testing.T) (string, <-chan []byte) {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	t.Cleanup(func() { _ = listener.Close() })
+
+	received := make(chan []byte, 1)
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- nil
+			return
+		}
+
+		defer conn.Close()
+
+		data, _ := io.ReadAll(conn)
+		received <- data
+	}()
+
+	return listener.Addr().String(), received
+}
+
+func testRows(n int) []map[string]string {
+	return sinktest.Rows(0, n, map[string]string{"Action": "CREATE\nline"})
+}
+
+func writeAndReceive(t *testing.T, framing string, rows []map[string]string) []byte {
+	t.Helper()
+
+	address, received := listenTCP(t)
+
+	syslog := newTestSyslog(t, Options{Network: NetworkTCP, Address: address, Framing: framing})
+
+	written, err := syslog.Write(context.Background(), sink.StreamAudit, rows)
+	if err != nil {
+		t.Fatalf("could not write rows: %v", err)
+	}
+
+	if written != len(rows) {
+		t.Fatalf("wrote %d rows, expected %d", written, len(rows))
+	}
+
+	if err := syslog.Close(); err != nil {
+		t.Fatalf("could not close: %v", err)
+	}
+
+	select {
+	case data := <-received:
+		return data
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for messages")
+		return nil
+	}
+}
+
+func TestOctetCountingFraming(t *testing.T) {
+	rows := testRows(3)
+
+	reader := bufio.NewReader(strings.NewReader(string(writeAndReceive(t, FramingOctetCounting, rows))))
+
+	for i := range rows {
+		length, err := reader.ReadString(' ')
+		if err != nil {
+			t.Fatalf("could not read length of message %d: %v", i, err)
+		}
+
+		size, err := strconv.Atoi(strings.TrimSuffix(length, " "))
+		if err != nil {
+			t.Fatalf("message %d has an invalid length '%s'", i, length)
+		}
+
+		message := make([]byte, size)
+		if _, err := io.ReadFull(reader, message); err != nil {
+			t.Fatalf("could not read message %d: %v", i, err)
+		}
+
+		if !strings.HasPrefix(string(message), "<133>1 2024-05-01T12:00:00.000000Z host tail2sen - audit ") {
+			t.Errorf("message %d has an unexpected header: %s", i, message)
+		}
+
+		// octet counting keeps the newline of a value inside the message
+		if !strings.Contains(string(message), fmt.Sprintf(`EventId="event-%d"`, i)) || !strings.Contains(string(message), "CREATE\nline") {
+			t.Errorf("message %d does not hold its row: %s", i, message)
+		}
+	}
+
+	if rest, _ := io.ReadAll(reader); len(rest) != 0 {
+		t.Fatalf("unexpected trailing data '%s'", rest)
+	}
+}
+
+func TestNonTransparentFraming(t *testing.T) {
+	rows := testRows(3)
+
+	data := string(writeAndReceive(t, FramingNonTransparent, rows))
+
+	if !strings.HasSuffix(data, "\n") {
+		t.Fatal("expected every message to end with a newline")
+	}
+
+	// the newline inside the row splits it over two lines, which is why octet counting is the default
+	lines := strings.Split(strings.TrimSuffix(data, "\n"), "\n")
+	if len(lines) != 2*len(rows) {
+		t.Fatalf("received %d lines, expected %d", len(lines), 2*len(rows))
+	}
+
+	for i := range rows {
+		if !strings.HasPrefix(lines[2*i], "<133>1 ") {
+			t.Errorf("message %d does not start with a header: %s", i, lines[2*i])
+		}
+	}
+}
+
+func TestUDPTruncatesMessages(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("could not listen: %v", err)
+	}
+
+	defer conn.Close()
+
+	syslog := newTestSyslog(t, Options{Network: NetworkUDP, Address: conn.LocalAddr().String(), MaxMessageSize: 200})
+
+	rows := sinktest.Rows(0, 1, map[string]string{"Changes": strings.Repeat("é", 500)})
+
+	written, err := syslog.Write(context.Background(), sink.StreamAudit, rows)
+	if err != nil {
+		t.Fatalf("could not write row: %v", err)
+	}
+
+	if written != 1 {
+		t.Fatalf("wrote %d rows, expected 1", written)
+	}
+
+	_ = conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	buf := make([]byte, 65536)
+
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("could not read datagram: %v", err)
+	}
+
+	if n > 200 {
+		t.Fatalf("received a datagram of %d bytes, over the max of 200", n)
+	}
+
+	if !utf8.Valid(buf[:n]) {
+		t.Fatal("truncation split a character")
+	}
+
+	if !strings.HasPrefix(string(buf[:n]), "<133>1 ") {
+		t.Fatalf("unexpected datagram: %s", buf[:n])
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		message   string
+		maxSize   int
+		expected  string
+		truncated bool
+	}{
+		{message: "abc", maxSize: 3, expected: "abc"},
+		{message: "abcd", maxSize: 3, expected: "abc", truncated: true},
+		// é is two bytes, so it is dropped instead of split
+		{message: "abé", maxSize: 3, expected: "ab", truncated: true},
+	}
+
+	for _, test := range tests {
+		message, truncated := truncate(test.message, test.maxSize)
+		if message != test.expected || truncated != test.truncated {
+			t.Errorf("truncate(%q, %d) = %q, %v, expected %q, %v",
+				test.message, test.maxSize, message, truncated, test.expected, test.truncated)
+		}
+	}
+}
@@ -24,12 +24,22 @@ type VirtualTraffic struct {
 	RxBytes int    `json:"rxBytes"`
 }
 
+type PhysicalTraffic struct {
+	Src     string `json:"src"`
+	Dst     string `json:"dst"`
+	TxPkts  int    `json:"txPkts"`
+	TxBytes int    `json:"txBytes"`
+	RxPkts  int    `json:"rxPkts"`
+	RxBytes int    `json:"rxBytes"`
+}
+
 type NetworkLog struct {
-	Logged         time.Time        `json:"logged"`
-	NodeID         string           `json:"nodeId"`
-	Start          time.Time        `json:"start"`
-	End            time.Time        `json:"end"`
-	VirtualTraffic []VirtualTraffic `json:"virtualTraffic"`
+	Logged          time.Time         `json:"logged"`
+	NodeID          string            `json:"nodeId"`
+	Start           time.Time         `json:"start"`
+	End             time.Time         `json:"end"`
+	VirtualTraffic  []VirtualTraffic  `json:"virtualTraffic"`
+	PhysicalTraffic []PhysicalTraffic `json:"physicalTraffic"`
 }
 
 func (ts *Tailscale) GetNetworkLogs(lookback time.Duration) ([]NetworkLog, error) {
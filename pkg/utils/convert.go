@@ -1,14 +1,20 @@
 package utils
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"github.com/hazcod/tail2sen/pkg/tailscale"
 	"github.com/sirupsen/logrus"
+	"time"
 )
 
 const (
 	iso8601Format = "2006-01-02T15:04:05Z"
+
+	trafficTypeVirtual  = "virtual"
+	trafficTypePhysical = "physical"
 )
 
 func toJson(obj interface{}) (string, error) {
@@ -25,6 +31,18 @@ func toJson(obj interface{}) (string, error) {
 	return string(b), nil
 }
 
+// eventID returns a stable identifier for a source record so repeated ingestion can be detected.
+func eventID(obj interface{}) (string, error) {
+	b, err := json.Marshal(&obj)
+	if err != nil {
+		return "", err
+	}
+
+	hash := sha256.Sum256(b)
+
+	return hex.EncodeToString(hash[:]), nil
+}
+
 func getIANAProtocolFromNumber(proto int) string {
 	switch proto {
 	case 0:
@@ -43,6 +61,8 @@ func getIANAProtocolFromNumber(proto int) string {
 		return "TCP"
 	case 7:
 		return "CBT"
+	case 17:
+		return "UDP"
 	case 41:
 		return "IPv6"
 	case 43:
@@ -82,8 +102,24 @@ func ConvertTSAuditToMap(_ *logrus.Logger, logs []tailscale.AuditLog) ([]map[str
 			return nil, fmt.Errorf("couldnt convert target: %v", err)
 		}
 
+		changes, err := DiffAuditValues(log.Old, log.New)
+		if err != nil {
+			return nil, fmt.Errorf("couldnt diff old and new: %v", err)
+		}
+
+		changeSet, err := toJson(changes)
+		if err != nil {
+			return nil, fmt.Errorf("couldnt convert changes: %v", err)
+		}
+
+		id, err := eventID(log)
+		if err != nil {
+			return nil, fmt.Errorf("couldnt compute event id: %v", err)
+		}
+
 		output[i] = map[string]string{
 			"TimeGenerated": log.EventTime.Format(iso8601Format),
+			"EventId":       id,
 			"Action":        log.Action,
 			"ActionType":    log.Type,
 			"Origin":        log.Origin,
@@ -91,23 +127,52 @@ func ConvertTSAuditToMap(_ *logrus.Logger, logs []tailscale.AuditLog) ([]map[str
 			"Target":        target,
 			"Old":           old,
 			"New":           edited,
+			"Changes":       changeSet,
 		}
 	}
 
 	return output, nil
 }
 
+// networkEventID identifies a single traffic entry of a network log, since a row is emitted per entry.
+func networkEventID(log tailscale.NetworkLog, trafficType string, index int, traffic interface{}) (string, error) {
+	return eventID(struct {
+		NodeID      string      `json:"nodeId"`
+		Logged      string      `json:"logged"`
+		Start       string      `json:"start"`
+		End         string      `json:"end"`
+		TrafficType string      `json:"trafficType"`
+		Index       int         `json:"index"`
+		Traffic     interface{} `json:"traffic"`
+	}{
+		NodeID:      log.NodeID,
+		Logged:      log.Logged.Format(time.RFC3339Nano),
+		Start:       log.Start.Format(time.RFC3339Nano),
+		End:         log.End.Format(time.RFC3339Nano),
+		TrafficType: trafficType,
+		Index:       index,
+		Traffic:     traffic,
+	})
+}
+
 func ConvertTSNetworkToMap(l *logrus.Logger, logs []tailscale.NetworkLog) ([]map[string]string, error) {
 	output := make([]map[string]string, 0)
 
 	for _, log := range logs {
 		for i, traffic := range log.VirtualTraffic {
+			id, err := networkEventID(log, trafficTypeVirtual, i, traffic)
+			if err != nil {
+				return nil, fmt.Errorf("couldnt compute event id: %v", err)
+			}
+
 			output = append(output, map[string]string{
 				"TimeGenerated": log.Logged.Format(iso8601Format),
+				"EventId":       id,
 				"NodeID":        log.NodeID,
 				"Start":         log.Start.Format(iso8601Format),
 				"End":           log.End.Format(iso8601Format),
 				"Index":         fmt.Sprintf("%d", i),
+				"TrafficType":   trafficTypeVirtual,
 
 				"Protocol": getIANAProtocolFromNumber(traffic.Proto),
 				"Src":      traffic.Src,
@@ -116,6 +181,30 @@ func ConvertTSNetworkToMap(l *logrus.Logger, logs []tailscale.NetworkLog) ([]map
 				"Packets":  fmt.Sprintf("%d", traffic.RxPkts),
 			})
 		}
+
+		for i, traffic := range log.PhysicalTraffic {
+			id, err := networkEventID(log, trafficTypePhysical, i, traffic)
+			if err != nil {
+				return nil, fmt.Errorf("couldnt compute event id: %v", err)
+			}
+
+			output = append(output, map[string]string{
+				"TimeGenerated": log.Logged.Format(iso8601Format),
+				"EventId":       id,
+				"NodeID":        log.NodeID,
+				"Start":         log.Start.Format(iso8601Format),
+				"End":           log.End.Format(iso8601Format),
+				"Index":         fmt.Sprintf("%d", i),
+				"TrafficType":   trafficTypePhysical,
+
+				// physical traffic is always wireguard over UDP
+				"Protocol": getIANAProtocolFromNumber(17),
+				"Src":      traffic.Src,
+				"Dst":      traffic.Dst,
+				"Bytes":    fmt.Sprintf("%d", traffic.RxBytes),
+				"Packets":  fmt.Sprintf("%d", traffic.RxPkts),
+			})
+		}
 	}
 
 	return output, nil
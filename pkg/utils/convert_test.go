@@ -0,0 +1,144 @@
+package utils
+
+import (
+	"encoding/json"
+	"github.com/hazcod/tail2sen/pkg/tailscale"
+	"github.com/sirupsen/logrus"
+	"io"
+	"testing"
+)
+
+const testNetworkLog = `{
+	"logged": "2024-05-01T12:00:05Z",
+	"nodeId": "node",
+	"start": "2024-05-01T11:59:55Z",
+	"end": "2024-05-01T12:00:00Z",
+	"virtualTraffic": [{"proto": 6, "src": "100.64.0.1:1234", "dst": "100.64.0.2:443", "rxPkts": 1, "rxBytes": 100}],
+	"physicalTraffic": [{"src": "100.64.0.1:1234", "dst": "100.64.0.2:443", "rxPkts": 1, "rxBytes": 100}]
+}`
+
+const testAuditLog = `{
+	"eventTime": "2024-05-01T12:00:00Z",
+	"type": "CONFIG",
+	"eventGroupID": "group",
+	"origin": "ADMIN_CONSOLE",
+	"actor": {"id": "user", "type": "USER"},
+	"target": {"id": "device", "type": "NODE"},
+	"action": "UPDATE",
+	"old": {"tags": ["tag:dev"], "keyExpiryDisabled": false},
+	"new": {"tags": ["tag:prod"], "keyExpiryDisabled": true}
+}`
+
+func testLogger() *logrus.Logger {
+	logger := logrus.New()
+	logger.SetOutput(io.Discard)
+	return logger
+}
+
+func decode[T any](t *testing.T, data string) T {
+	t.Helper()
+
+	var value T
+	if err := json.Unmarshal([]byte(data), &value); err != nil {
+		t.Fatalf("could not decode log: %v", err)
+	}
+
+	return value
+}
+
+func networkRows(t *testing.T) []map[string]string {
+	t.Helper()
+
+	rows, err := ConvertTSNetworkToMap(testLogger(), []tailscale.NetworkLog{decode[tailscale.NetworkLog](t, testNetworkLog)})
+	if err != nil {
+		t.Fatalf("could not convert network log: %v", err)
+	}
+
+	return rows
+}
+
+func auditRows(t *testing.T) []map[string]string {
+	t.Helper()
+
+	rows, err := ConvertTSAuditToMap(testLogger(), []tailscale.AuditLog{decode[tailscale.AuditLog](t, testAuditLog)})
+	if err != nil {
+		t.Fatalf("could not convert audit log: %v", err)
+	}
+
+	return rows
+}
+
+func TestNetworkEventIDIsStable(t *testing.T) {
+	// every fetch decodes the log again, so the id may only depend on its content
+	first, second := networkRows(t), networkRows(t)
+
+	if len(first) != 2 || len(second) != 2 {
+		t.Fatalf("expected a virtual and a physical row, got %d and %d", len(first), len(second))
+	}
+
+	for i := range first {
+		if first[i]["EventId"] == "" {
+			t.Fatalf("row %d has no event id", i)
+		}
+
+		if first[i]["EventId"] != second[i]["EventId"] {
+			t.Errorf("row %d hashed to '%s' and '%s'", i, first[i]["EventId"], second[i]["EventId"])
+		}
+	}
+}
+
+func TestNetworkEventIDByTrafficType(t *testing.T) {
+	rows := networkRows(t)
+
+	if rows[0]["TrafficType"] != trafficTypeVirtual || rows[1]["TrafficType"] != trafficTypePhysical {
+		t.Fatalf("unexpected traffic types '%s' and '%s'", rows[0]["TrafficType"], rows[1]["TrafficType"])
+	}
+
+	// both entries are at index 0 with the same addresses and counters
+	if rows[0]["Index"] != rows[1]["Index"] {
+		t.Fatalf("expected the entries to share an index, got '%s' and '%s'", rows[0]["Index"], rows[1]["Index"])
+	}
+
+	if rows[0]["EventId"] == rows[1]["EventId"] {
+		t.Fatal("virtual and physical entries hashed to the same event id")
+	}
+}
+
+func TestNetworkEventIDByIndex(t *testing.T) {
+	log := decode[tailscale.NetworkLog](t, testNetworkLog)
+	log.VirtualTraffic = append(log.VirtualTraffic, log.VirtualTraffic[0])
+	log.PhysicalTraffic = nil
+
+	rows, err := ConvertTSNetworkToMap(testLogger(), []tailscale.NetworkLog{log})
+	if err != nil {
+		t.Fatalf("could not convert network log: %v", err)
+	}
+
+	if rows[0]["EventId"] == rows[1]["EventId"] {
+		t.Fatal("identical entries at different indexes hashed to the same event id")
+	}
+}
+
+func TestAuditEventIDIsStable(t *testing.T) {
+	first, second := auditRows(t), auditRows(t)
+
+	if first[0]["EventId"] == "" {
+		t.Fatal("row has no event id")
+	}
+
+	if first[0]["EventId"] != second[0]["EventId"] {
+		t.Fatalf("audit log hashed to '%s' and '%s'", first[0]["EventId"], second[0]["EventId"])
+	}
+
+	changed := decode[tailscale.AuditLog](t, testAuditLog)
+	changed.Action = "DELETE"
+
+	rows, err := ConvertTSAuditToMap(testLogger(), []tailscale.AuditLog{changed})
+	if err != nil {
+		t.Fatalf("could not convert audit log: %v", err)
+	}
+
+	if rows[0]["EventId"] == first[0]["EventId"] {
+		t.Fatal("different audit logs hashed to the same event id")
+	}
+}
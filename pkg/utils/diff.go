@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+const (
+	changeAdded   = "added"
+	changeRemoved = "removed"
+	changeChanged = "changed"
+)
+
+// Change describes a single difference between the old and new value of an audit log.
+type Change struct {
+	Path string      `json:"path"`
+	Op   string      `json:"op"`
+	Old  interface{} `json:"old,omitempty"`
+	New  interface{} `json:"new,omitempty"`
+}
+
+// normalize converts a decoded audit value into plain maps, slices and scalars.
+// Strings holding JSON documents, such as policy files, are decoded so they can be compared structurally.
+func normalize(obj interface{}) (interface{}, error) {
+	if str, ok := obj.(string); ok {
+		trimmed := strings.TrimSpace(str)
+		if strings.HasPrefix(trimmed, "{") || strings.HasPrefix(trimmed, "[") {
+			var decoded interface{}
+			if err := json.Unmarshal([]byte(trimmed), &decoded); err == nil {
+				return decoded, nil
+			}
+		}
+
+		return str, nil
+	}
+
+	b, err := json.Marshal(&obj)
+	if err != nil {
+		return nil, err
+	}
+
+	var decoded interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		return nil, err
+	}
+
+	return decoded, nil
+}
+
+func joinPath(parent, key string) string {
+	if parent == "" {
+		return key
+	}
+
+	return parent + "." + key
+}
+
+func isScalarList(list []interface{}) bool {
+	for _, item := range list {
+		switch item.(type) {
+		case map[string]interface{}, []interface{}:
+			return false
+		}
+	}
+
+	return true
+}
+
+func diffMaps(path string, old, new map[string]interface{}) []Change {
+	keys := make(map[string]struct{}, len(old)+len(new))
+	for key := range old {
+		keys[key] = struct{}{}
+	}
+	for key := range new {
+		keys[key] = struct{}{}
+	}
+
+	sortedKeys := make([]string, 0, len(keys))
+	for key := range keys {
+		sortedKeys = append(sortedKeys, key)
+	}
+	sort.Strings(sortedKeys)
+
+	var changes []Change
+
+	for _, key := range sortedKeys {
+		oldValue, inOld := old[key]
+		newValue, inNew := new[key]
+
+		switch {
+		case !inOld:
+			changes = append(changes, Change{Path: joinPath(path, key), Op: changeAdded, New: newValue})
+		case !inNew:
+			changes = append(changes, Change{Path: joinPath(path, key), Op: changeRemoved, Old: oldValue})
+		default:
+			changes = append(changes, diffValues(joinPath(path, key), oldValue, newValue)...)
+		}
+	}
+
+	return changes
+}
+
+// diffScalarLists compares lists such as tags or IP sets, where order does not matter.
+func diffScalarLists(path string, old, new []interface{}) []Change {
+	var changes []Change
+
+	for _, item := range old {
+		if !containsValue(new, item) {
+			changes = append(changes, Change{Path: path, Op: changeRemoved, Old: item})
+		}
+	}
+
+	for _, item := range new {
+		if !containsValue(old, item) {
+			changes = append(changes, Change{Path: path, Op: changeAdded, New: item})
+		}
+	}
+
+	return changes
+}
+
+func containsValue(list []interface{}, value interface{}) bool {
+	for _, item := range list {
+		if reflect.DeepEqual(item, value) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func diffLists(path string, old, new []interface{}) []Change {
+	if isScalarList(old) && isScalarList(new) {
+		return diffScalarLists(path, old, new)
+	}
+
+	var changes []Change
+
+	for i := 0; i < len(old) || i < len(new); i++ {
+		itemPath := fmt.Sprintf("%s[%d]", path, i)
+
+		switch {
+		case i >= len(old):
+			changes = append(changes, Change{Path: itemPath, Op: changeAdded, New: new[i]})
+		case i >= len(new):
+			changes = append(changes, Change{Path: itemPath, Op: changeRemoved, Old: old[i]})
+		default:
+			changes = append(changes, diffValues(itemPath, old[i], new[i])...)
+		}
+	}
+
+	return changes
+}
+
+func diffValues(path string, old, new interface{}) []Change {
+	oldMap, oldIsMap := old.(map[string]interface{})
+	newMap, newIsMap := new.(map[string]interface{})
+
+	// a missing value on either side is treated as empty, so every field shows up as added or removed
+	if old == nil && newIsMap {
+		oldMap, oldIsMap = map[string]interface{}{}, true
+	}
+	if new == nil && oldIsMap {
+		newMap, newIsMap = map[string]interface{}{}, true
+	}
+
+	if oldIsMap && newIsMap {
+		return diffMaps(path, oldMap, newMap)
+	}
+
+	oldList, oldIsList := old.([]interface{})
+	newList, newIsList := new.([]interface{})
+
+	if old == nil && newIsList {
+		oldList, oldIsList = []interface{}{}, true
+	}
+	if new == nil && oldIsList {
+		newList, newIsList = []interface{}{}, true
+	}
+
+	if oldIsList && newIsList {
+		return diffLists(path, oldList, newList)
+	}
+
+	switch {
+	case reflect.DeepEqual(old, new):
+		return nil
+	case old == nil:
+		return []Change{{Path: path, Op: changeAdded, New: new}}
+	case new == nil:
+		return []Change{{Path: path, Op: changeRemoved, Old: old}}
+	default:
+		return []Change{{Path: path, Op: changeChanged, Old: old, New: new}}
+	}
+}
+
+// DiffAuditValues computes the structured change set between the old and new value of an audit log.
+func DiffAuditValues(old, new interface{}) ([]Change, error) {
+	normalizedOld, err := normalize(old)
+	if err != nil {
+		return nil, fmt.Errorf("could not normalize old value: %v", err)
+	}
+
+	normalizedNew, err := normalize(new)
+	if err != nil {
+		return nil, fmt.Errorf("could not normalize new value: %v", err)
+	}
+
+	changes := diffValues("", normalizedOld, normalizedNew)
+	if changes == nil {
+		changes = []Change{}
+	}
+
+	return changes, nil
+}
@@ -0,0 +1,126 @@
+package utils
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestDiffAuditValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		old      interface{}
+		new      interface{}
+		expected string
+	}{
+		{
+			name:     "equal values",
+			old:      map[string]interface{}{"name": "device"},
+			new:      map[string]interface{}{"name": "device"},
+			expected: `[]`,
+		},
+		{
+			name:     "both nil",
+			expected: `[]`,
+		},
+		{
+			name:     "scalar changed",
+			old:      "device",
+			new:      "server",
+			expected: `[{"path":"","op":"changed","old":"device","new":"server"}]`,
+		},
+		{
+			name: "scalar list items added and removed",
+			old:  map[string]interface{}{"tags": []string{"tag:dev", "tag:web"}},
+			new:  map[string]interface{}{"tags": []string{"tag:web", "tag:prod"}},
+			expected: `[{"path":"tags","op":"removed","old":"tag:dev"},` +
+				`{"path":"tags","op":"added","new":"tag:prod"}]`,
+		},
+		{
+			name:     "scalar list order is ignored",
+			old:      []string{"100.64.0.1", "100.64.0.2"},
+			new:      []string{"100.64.0.2", "100.64.0.1"},
+			expected: `[]`,
+		},
+		{
+			name: "nested map paths",
+			old: map[string]interface{}{
+				"device": map[string]interface{}{"name": "a", "keyExpiry": map[string]interface{}{"disabled": false}},
+			},
+			new: map[string]interface{}{
+				"device": map[string]interface{}{"keyExpiry": map[string]interface{}{"disabled": true}, "os": "linux"},
+			},
+			expected: `[{"path":"device.keyExpiry.disabled","op":"changed","old":false,"new":true},` +
+				`{"path":"device.name","op":"removed","old":"a"},` +
+				`{"path":"device.os","op":"added","new":"linux"}]`,
+		},
+		{
+			name: "indexed object lists",
+			old: map[string]interface{}{"acls": []interface{}{
+				map[string]interface{}{"action": "accept", "src": "group:a"},
+				map[string]interface{}{"action": "accept", "src": "group:b"},
+			}},
+			new: map[string]interface{}{"acls": []interface{}{
+				map[string]interface{}{"action": "accept", "src": "group:c"},
+			}},
+			expected: `[{"path":"acls[0].src","op":"changed","old":"group:a","new":"group:c"},` +
+				`{"path":"acls[1]","op":"removed","old":{"action":"accept","src":"group:b"}}]`,
+		},
+		{
+			name:     "JSON policy strings are compared structurally",
+			old:      `{"acls": [{"action": "accept", "src": ["*"]}], "tagOwners": {}}`,
+			new:      "{\n  \"tagOwners\": {},\n  \"acls\": [{\"src\": [\"*\"], \"action\": \"accept\"}]\n}",
+			expected: `[]`,
+		},
+		{
+			name:     "JSON policy strings with changes",
+			old:      `{"acls": [{"action": "accept", "src": ["group:a"]}]}`,
+			new:      `{"acls": [{"action": "accept", "src": ["group:a", "group:b"]}]}`,
+			expected: `[{"path":"acls[0].src","op":"added","new":"group:b"}]`,
+		},
+		{
+			name:     "strings that are not JSON",
+			old:      "{not json",
+			new:      "{not json either",
+			expected: `[{"path":"","op":"changed","old":"{not json","new":"{not json either"}]`,
+		},
+		{
+			name: "nil old map",
+			new:  map[string]interface{}{"name": "device", "tags": []string{"tag:prod"}},
+			expected: `[{"path":"name","op":"added","new":"device"},` +
+				`{"path":"tags","op":"added","new":["tag:prod"]}]`,
+		},
+		{
+			name:     "nil new map",
+			old:      map[string]interface{}{"name": "device"},
+			expected: `[{"path":"name","op":"removed","old":"device"}]`,
+		},
+		{
+			name:     "nil old list",
+			new:      []string{"tag:prod"},
+			expected: `[{"path":"","op":"added","new":"tag:prod"}]`,
+		},
+		{
+			name:     "nil new scalar",
+			old:      "device",
+			expected: `[{"path":"","op":"removed","old":"device"}]`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes, err := DiffAuditValues(test.old, test.new)
+			if err != nil {
+				t.Fatalf("could not diff values: %v", err)
+			}
+
+			b, err := json.Marshal(changes)
+			if err != nil {
+				t.Fatalf("could not encode changes: %v", err)
+			}
+
+			if string(b) != test.expected {
+				t.Fatalf("got %s, expected %s", b, test.expected)
+			}
+		})
+	}
+}
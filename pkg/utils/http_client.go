@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"crypto/tls"
 	"fmt"
 	"github.com/sirupsen/logrus"
 	"net/http"
@@ -9,6 +10,11 @@ import (
 )
 
 func NewLogHttpClient(logger *logrus.Logger) *http.Client {
+	return NewLogHttpClientWithTLS(logger, nil)
+}
+
+// NewLogHttpClientWithTLS is NewLogHttpClient with a custom tls configuration, nil uses the defaults.
+func NewLogHttpClientWithTLS(logger *logrus.Logger, tlsConfig *tls.Config) *http.Client {
 	httpClient := &http.Client{
 		Timeout: time.Minute,
 	}
@@ -17,9 +23,16 @@ func NewLogHttpClient(logger *logrus.Logger) *http.Client {
 		logger = logrus.New()
 	}
 
+	var transport http.RoundTripper = http.DefaultTransport
+	if tlsConfig != nil {
+		customTransport := http.DefaultTransport.(*http.Transport).Clone()
+		customTransport.TLSClientConfig = tlsConfig
+		transport = customTransport
+	}
+
 	httpClient.Transport = &loggingTransport{
 		logger:    logger,
-		transport: http.DefaultTransport,
+		transport: transport,
 	}
 
 	return httpClient
@@ -39,7 +52,7 @@ func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		if err != nil {
 			t.logger.Errorf("Error dumping request: %v", err)
 		} else {
-			fmt.Println("Request:\n")
+			fmt.Println("Request:")
 			fmt.Println(string(requestDump))
 		}
 	}
@@ -56,7 +69,7 @@ func (t *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error)
 		if err != nil {
 			t.logger.Errorf("Error dumping response: %v", err)
 		} else {
-			fmt.Println("Response:\n")
+			fmt.Println("Response:")
 			fmt.Println(string(responseDump))
 		}
 	}
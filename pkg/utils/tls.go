@@ -0,0 +1,55 @@
+package utils
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// TLSOptions configures the TLS connections of a sink, zero values use the system defaults.
+type TLSOptions struct {
+	// CAFile is a PEM bundle trusted in addition to the system roots.
+	CAFile string
+	// CertFile and KeyFile are a PEM client certificate and key for mutual TLS.
+	CertFile           string
+	KeyFile            string
+	InsecureSkipVerify bool
+}
+
+// Config builds the tls configuration of the options.
+func (o TLSOptions) Config() (*tls.Config, error) {
+	tlsConfig := tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		InsecureSkipVerify: o.InsecureSkipVerify,
+	}
+
+	if o.CAFile != "" {
+		caBytes, err := os.ReadFile(o.CAFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read ca file '%s': %v", o.CAFile, err)
+		}
+
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+
+		if !pool.AppendCertsFromPEM(caBytes) {
+			return nil, fmt.Errorf("no certificates found in ca file '%s'", o.CAFile)
+		}
+
+		tlsConfig.RootCAs = pool
+	}
+
+	if o.CertFile != "" || o.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(o.CertFile, o.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not load client certificate: %v", err)
+		}
+
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return &tlsConfig, nil
+}